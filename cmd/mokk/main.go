@@ -0,0 +1,305 @@
+// Command mokk serves the routes of a mock definition file on a real HTTP listener
+// Changes of the definition file are picked up without a restart
+// With -proxy the requests which match no route pass through to an upstream server,
+// with -record the unmatched requests are proxied to an upstream server and recorded,
+// the recordings are written to the -record-file on shutdown
+//
+// Usage:
+//
+//	mokk [-addr :8080] [-h2c] [-watch 1s] [-admin /__mokk/] [-proxy http://localhost:9000] -file mocks.yml
+//	mokk [-addr :8080] [-file mocks.yml] -record https://api.example.com -record-file recorded.yml
+//	mokk -record https://api.example.com -record-file mocks/recorded.go [-record-package mocks]
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+	"unicode"
+
+	"github.com/mikloslorinczi/mokk/server"
+	"github.com/pkg/errors"
+)
+
+// shutdownTimeout is the time outstanding requests get to complete on shutdown
+const shutdownTimeout = 5 * time.Second
+
+func main() {
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	os.Exit(run(os.Args[1:], os.Stderr, stop))
+}
+
+// config is the parsed command line of mokk
+type config struct {
+	addr       string
+	file       string
+	admin      string
+	watch      time.Duration
+	h2c        bool
+	proxy      string
+	record     string
+	recordFile string
+	recordPkg  string
+}
+
+// parseFlags parses the arguments into a config, printing the usage to output on errors
+func parseFlags(args []string, output io.Writer) (*config, error) {
+	cfg := &config{}
+	flags := flag.NewFlagSet("mokk", flag.ContinueOnError)
+	flags.SetOutput(output)
+	flags.StringVar(&cfg.addr, "addr", ":8080", "address to listen on")
+	flags.StringVar(&cfg.file, "file", "", "mock definition file (YAML or JSON), optional with -record")
+	flags.StringVar(&cfg.admin, "admin", "", "path prefix of the admin API (e.g. /__mokk/), empty disables it")
+	flags.DurationVar(&cfg.watch, "watch", time.Second,
+		"interval of checking the definition file for changes, 0 disables it")
+	flags.BoolVar(&cfg.h2c, "h2c", false, "serve HTTP/2 without TLS (h2c) besides HTTP/1.1")
+	flags.StringVar(&cfg.proxy, "proxy", "", "upstream URL to pass the unmatched requests through to")
+	flags.StringVar(&cfg.record, "record", "", "upstream URL to proxy and record the unmatched requests to")
+	flags.StringVar(&cfg.recordFile, "record-file", "recorded.yml",
+		"file the recordings are written to on shutdown (YAML, JSON or Go code by its extension)")
+	flags.StringVar(&cfg.recordPkg, "record-package", "",
+		"package of the Go code recordings, the name of the -record-file's directory by default")
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+	var err error
+	switch {
+	case cfg.file == "" && cfg.record == "":
+		err = errors.New("Missing required flag: -file")
+	case cfg.proxy != "" && cfg.record != "":
+		err = errors.New("The -proxy and -record flags are mutually exclusive")
+	}
+	if err != nil {
+		fmt.Fprintln(output, err)
+		flags.Usage()
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// run parses the arguments, loads the definition file and serves it until a signal arrives on stop
+// It returns the exit code of the command
+func run(args []string, output io.Writer, stop <-chan os.Signal) int {
+	cfg, err := parseFlags(args, output)
+	if err != nil {
+		return 2
+	}
+	logger := log.New(output, "mokk: ", log.LstdFlags)
+	errHandler := server.NewLogErrorHandler(logger)
+	router, state, err := loadRouter(cfg.file, cfg.admin, errHandler)
+	if err != nil {
+		logger.Printf("Configuration error: %s", err)
+		return 1
+	}
+	recorder, err := addFallback(cfg, router, errHandler)
+	if err != nil {
+		logger.Printf("Configuration error: %s", err)
+		return 1
+	}
+	listener, err := net.Listen("tcp", cfg.addr)
+	if err != nil {
+		logger.Printf("Configuration error: %s", err)
+		return 1
+	}
+	done := make(chan struct{})
+	defer close(done)
+	if cfg.watch > 0 && cfg.file != "" {
+		go watchDefinition(cfg.file, state, cfg.watch, router, errHandler, logger, done)
+	}
+	code := serve(newHTTPServer(logRequests(logger, router), cfg.h2c), listener, stop, logger)
+	if recorder != nil {
+		if err := writeRecordings(recorder, cfg.recordFile, cfg.recordPkg); err != nil {
+			logger.Printf("Recording error: %s", err)
+			return 1
+		}
+		logger.Printf("Wrote %d recording(s) to %s", len(recorder.Recordings()), cfg.recordFile)
+	}
+	return code
+}
+
+// newHTTPServer creates the http.Server of the handler, serving HTTP/2 without TLS besides HTTP/1.1 if h2c is true
+func newHTTPServer(handler http.Handler, h2c bool) *http.Server {
+	srv := &http.Server{Handler: handler}
+	if h2c {
+		srv.Protocols = new(http.Protocols)
+		srv.Protocols.SetHTTP1(true)
+		srv.Protocols.SetUnencryptedHTTP2(true)
+	}
+	return srv
+}
+
+// serve serves the listener until the server fails or a signal arrives on stop, then shuts the server down
+// It returns the exit code of the command
+func serve(srv *http.Server, listener net.Listener, stop <-chan os.Signal, logger *log.Logger) int {
+	failed := make(chan error, 1)
+	go func() {
+		logger.Printf("Serving on %s", listener.Addr())
+		failed <- srv.Serve(listener)
+	}()
+	select {
+	case err := <-failed:
+		logger.Printf("Server error: %s", err)
+		return 1
+	case sig := <-stop:
+		logger.Printf("Received %s, shutting down", sig)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		logger.Printf("Shutdown error: %s", err)
+		return 1
+	}
+	return 0
+}
+
+// loadRouter loads the Router from the definition file and enables its admin API if the prefix is not empty
+// Without a definition file an empty Router is created, which is only useful with a recorder
+// It also returns the state of the file, which is taken before loading,
+// so changes made during the loading are picked up by the watcher
+func loadRouter(file, admin string, errHandler server.ErrorHandler) (*server.Router, fileState, error) {
+	var state fileState
+	router := server.NewRouter(errHandler)
+	if file != "" {
+		var err error
+		if state, err = statFile(file); err != nil {
+			return nil, state, err
+		}
+		if router, err = server.LoadRouterFromFile(file, errHandler); err != nil {
+			return nil, state, err
+		}
+	}
+	if admin != "" {
+		router.AddAdmin(admin)
+	}
+	return router, state, nil
+}
+
+// addFallback sets a Proxy or a Recorder as the Router's fallback, as configured
+// It returns the Recorder, or nil if the requests are not recorded
+func addFallback(cfg *config, router *server.Router, errHandler server.ErrorHandler) (*server.Recorder, error) {
+	if cfg.proxy != "" {
+		return nil, router.AddProxy(cfg.proxy)
+	}
+	if cfg.record == "" {
+		return nil, nil
+	}
+	recorder, err := server.NewRecorder(cfg.record, errHandler)
+	if err != nil {
+		return nil, err
+	}
+	router.AddFallback(recorder)
+	return recorder, nil
+}
+
+// writeRecordings writes the recordings into the file, as Go code of the package if its extension is .go,
+// as a mock definition otherwise
+// Without a package the Go code's package is named after the file's directory
+func writeRecordings(recorder *server.Recorder, file, pkg string) error {
+	if !strings.EqualFold(filepath.Ext(file), ".go") {
+		return recorder.WriteDefinitionFile(file)
+	}
+	if pkg == "" {
+		pkg = packageName(file)
+	}
+	var code bytes.Buffer
+	if err := recorder.WriteGo(&code, pkg); err != nil {
+		return err
+	}
+	return errors.Wrap(ioutil.WriteFile(file, code.Bytes(), 0644), "Cannot write recordings")
+}
+
+// packageName returns a valid Go package name from the name of the file's directory,
+// keeping only its letters, digits and underscores, or mocks if nothing remains
+func packageName(file string) string {
+	dir, err := filepath.Abs(filepath.Dir(file))
+	if err != nil {
+		return "mocks"
+	}
+	name := strings.Map(func(r rune) rune {
+		if r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, filepath.Base(dir))
+	if name == "" || unicode.IsDigit([]rune(name)[0]) {
+		return "mocks"
+	}
+	return name
+}
+
+// logRequests is a middleware which logs the method, URL, status and duration of every request
+func logRequests(logger *log.Logger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: res}
+		next.ServeHTTP(recorder, req)
+		logger.Printf("%s %s %s %s", req.Method, req.URL, recorder.statusText(), time.Since(start))
+	})
+}
+
+// statusRecorder is a ResponseWriter which remembers the written status code
+// It keeps the Flusher and Hijacker capabilities of the wrapped ResponseWriter
+type statusRecorder struct {
+	http.ResponseWriter
+	status   int
+	hijacked bool
+}
+
+// WriteHeader records the status code and writes it
+func (recorder *statusRecorder) WriteHeader(status int) {
+	if recorder.status == 0 {
+		recorder.status = status
+	}
+	recorder.ResponseWriter.WriteHeader(status)
+}
+
+// Write records the implicit 200 OK status and writes the data
+func (recorder *statusRecorder) Write(data []byte) (int, error) {
+	if recorder.status == 0 {
+		recorder.status = http.StatusOK
+	}
+	return recorder.ResponseWriter.Write(data)
+}
+
+// Flush flushes the wrapped ResponseWriter, if it supports it
+func (recorder *statusRecorder) Flush() {
+	if flusher, ok := recorder.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack hijacks the wrapped ResponseWriter's connection, if it supports it
+func (recorder *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := recorder.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.Errorf("%T does not support hijacking", recorder.ResponseWriter)
+	}
+	recorder.hijacked = true
+	return hijacker.Hijack()
+}
+
+// statusText returns the recorded status for logging
+func (recorder *statusRecorder) statusText() string {
+	switch {
+	case recorder.hijacked:
+		return "hijacked"
+	case recorder.status == 0:
+		return "no response"
+	default:
+		return fmt.Sprint(recorder.status)
+	}
+}
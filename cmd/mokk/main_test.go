@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mikloslorinczi/mokk/server"
+	"github.com/stretchr/testify/require"
+)
+
+// syncBuffer is a bytes.Buffer safe for concurrent use by the logger and the test
+type syncBuffer struct {
+	buffer bytes.Buffer
+	mutex  sync.Mutex
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buffer.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buffer.String()
+}
+
+func TestRun_configuration_errors(t *testing.T) {
+	t.Log("Testing configuration errors...")
+
+	var output syncBuffer
+	require.Equal(t, 2, run([]string{}, &output, nil), "Missing -file should exit with 2")
+	require.Equal(t, 2, run([]string{"-unknown"}, &output, nil), "Unknown flags should exit with 2")
+	require.Equal(t, 2, run([]string{"-file", "a.yml", "-proxy", "http://a", "-record", "http://b"}, &output, nil),
+		"Proxy and record should exit with 2")
+	require.Equal(t, 1, run([]string{"-file", "missing.yml"}, &output, nil), "Missing files should exit with 1")
+
+	dir, err := ioutil.TempDir("", "mokk")
+	require.NoError(t, err, "The temp dir should be created")
+	defer os.RemoveAll(dir)
+	invalid := filepath.Join(dir, "invalid.yml")
+	require.NoError(t, ioutil.WriteFile(invalid, []byte("routes: [{regex: '('}]"), 0600), "The file should be written")
+	require.Equal(t, 1, run([]string{"-file", invalid}, &output, nil), "Invalid definitions should exit with 1")
+	valid := filepath.Join(dir, "valid.yml")
+	require.NoError(t, ioutil.WriteFile(valid, []byte("routes: []"), 0600), "The file should be written")
+	require.Equal(t, 1, run([]string{"-file", valid, "-proxy", "invalid"}, &output, nil),
+		"Invalid upstream URLs should exit with 1")
+}
+
+func TestRun(t *testing.T) {
+	t.Log("Testing serving a definition file...")
+
+	dir, err := ioutil.TempDir("", "mokk")
+	require.NoError(t, err, "The temp dir should be created")
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "mocks.yml")
+	definition := "routes: [{regex: '^/health$', response: {status: 204}}]"
+	require.NoError(t, ioutil.WriteFile(file, []byte(definition), 0600), "The file should be written")
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err, "A free port should be found")
+	addr := listener.Addr().String()
+	require.NoError(t, listener.Close(), "The port should be released")
+
+	var output syncBuffer
+	stop := make(chan os.Signal, 1)
+	exit := make(chan int)
+	go func() {
+		exit <- run([]string{"-addr", addr, "-admin", "/__mokk/", "-file", file}, &output, stop)
+	}()
+
+	var resp *http.Response
+	for i := 0; i < 50; i++ {
+		if resp, err = http.Get("http://" + addr + "/health"); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	require.NoError(t, err, "The server should be reachable")
+	resp.Body.Close()
+	require.Equal(t, http.StatusNoContent, resp.StatusCode, "The definition should be served")
+
+	adminResp, err := http.Get("http://" + addr + "/__mokk/routes")
+	require.NoError(t, err, "The admin API should be reachable")
+	adminResp.Body.Close()
+	require.Equal(t, http.StatusOK, adminResp.StatusCode, "The admin API should be enabled")
+
+	stop <- os.Interrupt
+	require.Equal(t, 0, <-exit, "The server should shut down cleanly")
+	require.Contains(t, output.String(), "GET /health 204", "The request should be logged")
+}
+
+func TestRun_record(t *testing.T) {
+	t.Log("Testing recording an upstream server...")
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.WriteHeader(http.StatusTeapot)
+		res.Write([]byte("recorded"))
+	}))
+	defer upstream.Close()
+
+	dir, err := ioutil.TempDir("", "mokk")
+	require.NoError(t, err, "The temp dir should be created")
+	defer os.RemoveAll(dir)
+	recordFile := filepath.Join(dir, "recorded.yml")
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err, "A free port should be found")
+	addr := listener.Addr().String()
+	require.NoError(t, listener.Close(), "The port should be released")
+
+	var output syncBuffer
+	stop := make(chan os.Signal, 1)
+	exit := make(chan int)
+	go func() {
+		exit <- run([]string{"-addr", addr, "-record", upstream.URL, "-record-file", recordFile}, &output, stop)
+	}()
+
+	var resp *http.Response
+	for i := 0; i < 50; i++ {
+		if resp, err = http.Get("http://" + addr + "/tea"); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	require.NoError(t, err, "The server should be reachable")
+	resp.Body.Close()
+	require.Equal(t, http.StatusTeapot, resp.StatusCode, "The request should be proxied to the upstream")
+
+	stop <- os.Interrupt
+	require.Equal(t, 0, <-exit, "The server should shut down cleanly")
+
+	definition, err := server.LoadDefinitionFile(recordFile)
+	require.NoError(t, err, "The recordings should be written as a definition")
+	require.Len(t, definition.Routes, 1, "The request should be recorded")
+	require.Equal(t, "^/tea$", definition.Routes[0].Regex, "The path should be recorded")
+	require.Equal(t, http.StatusTeapot, definition.Routes[0].Response.Status, "The status should be recorded")
+	require.Equal(t, "recorded", *definition.Routes[0].Response.Body, "The body should be recorded")
+}
+
+func TestPackageName(t *testing.T) {
+	t.Log("Testing the package name of Go code recordings...")
+
+	require.Equal(t, "mocks", packageName(filepath.Join("testdata", "Mocks", "recorded.go")),
+		"The package should be named after the directory")
+	require.Equal(t, "apiv2", packageName(filepath.Join("api-v2", "recorded.go")),
+		"Invalid characters should be dropped")
+	require.Equal(t, "mocks", packageName(filepath.Join("2019", "recorded.go")),
+		"Invalid names should fall back to mocks")
+}
+
+func TestNewHTTPServer(t *testing.T) {
+	t.Log("Testing the HTTP server's protocols...")
+
+	require.Nil(t, newHTTPServer(http.NotFoundHandler(), false).Protocols, "The default protocols should be used")
+	protocols := newHTTPServer(http.NotFoundHandler(), true).Protocols
+	require.NotNil(t, protocols, "The protocols should be set with -h2c")
+	require.True(t, protocols.HTTP1(), "HTTP/1.1 should be served with -h2c")
+	require.True(t, protocols.UnencryptedHTTP2(), "h2c should be served with -h2c")
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"crypto/sha256"
+	"io/ioutil"
+	"log"
+	"time"
+
+	"github.com/mikloslorinczi/mokk/server"
+)
+
+// fileState is the hash of a file's content used to detect changes
+// The content is compared instead of the modification time, which is too coarse on some file systems
+type fileState [sha256.Size]byte
+
+// statFile returns the current state of the file
+func statFile(path string) (fileState, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fileState{}, err
+	}
+	return sha256.Sum256(content), nil
+}
+
+// watchDefinition polls the definition file every interval until done is closed
+// When the file's content differs from the last one it is loaded again
+// and the router's routes and scenarios are replaced together atomically, the scenarios starting over
+// If the new definition is invalid the error is logged and the previous routes are kept
+// The stubs added and the scenario states changed through the admin API do not survive a reload,
+// this is logged if the admin API is enabled
+func watchDefinition(
+	file string,
+	last fileState,
+	interval time.Duration,
+	router *server.Router,
+	errHandler server.ErrorHandler,
+	logger *log.Logger,
+	done <-chan struct{},
+) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		current, err := statFile(file)
+		if err != nil || current == last {
+			continue
+		}
+		last = current
+		reloaded, err := server.LoadRouterFromFile(file, errHandler)
+		if err != nil {
+			logger.Printf("Reload failed, keeping the previous routes: %s", err)
+			continue
+		}
+		router.ReplaceWith(reloaded)
+		if router.Admin() != nil {
+			logger.Printf("Reloaded %s, the stubs and scenario states of the admin API are discarded", file)
+		} else {
+			logger.Printf("Reloaded %s", file)
+		}
+	}
+}
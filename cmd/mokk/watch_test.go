@@ -0,0 +1,80 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mikloslorinczi/mokk/server"
+	"github.com/stretchr/testify/require"
+)
+
+// writeDefinition replaces the definition file with one of the given modification time
+// The file is renamed into place, so the watcher never reads it half written
+func writeDefinition(t *testing.T, file string, definition string, modTime time.Time) {
+	written := file + ".tmp"
+	require.NoError(t, ioutil.WriteFile(written, []byte(definition), 0600), "The file should be written")
+	require.NoError(t, os.Chtimes(written, modTime, modTime), "The modification time should be set")
+	require.NoError(t, os.Rename(written, file), "The file should be replaced")
+}
+
+func TestWatchDefinition(t *testing.T) {
+	t.Log("Testing reloading the definition file...")
+
+	dir, err := ioutil.TempDir("", "mokk")
+	require.NoError(t, err, "The temp dir should be created")
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "mocks.yml")
+	now := time.Now()
+	writeDefinition(t, file, "routes: [{regex: '^/first$'}]", now)
+
+	var output syncBuffer
+	logger := log.New(&output, "", 0)
+	state, err := statFile(file)
+	require.NoError(t, err, "The file state should be read")
+	router, err := server.LoadRouterFromFile(file, nil)
+	require.NoError(t, err, "The definition should be loaded")
+	router.AddAdmin("/__mokk/")
+	done := make(chan struct{})
+	defer close(done)
+	go watchDefinition(file, state, 5*time.Millisecond, router, nil, logger, done)
+
+	regexes := func() []string {
+		var result []string
+		for _, route := range router.Routes() {
+			result = append(result, route.Regex())
+		}
+		return result
+	}
+
+	writeDefinition(t, file, "routes: [{regex: '^/second$'}, {regex: '^/third$'}]", now)
+	require.True(t, eventually(func() bool { return len(regexes()) == 2 }), "The routes should be reloaded")
+	require.Equal(t, []string{"^/second$", "^/third$"}, regexes(), "The new routes should be served")
+	require.True(t,
+		eventually(func() bool { return strings.Contains(output.String(), "admin API are discarded") }),
+		"Discarding the admin API's stubs should be logged")
+
+	writeDefinition(t, file, "routes: [{regex: '^/fourth$'}, {regex: '^/fifth$'}]", now)
+	require.True(t, eventually(func() bool { return regexes()[0] == "^/fourth$" }),
+		"Changes of the same size and modification time should be reloaded")
+
+	writeDefinition(t, file, "routes: [{regex: '('}]", now)
+	require.True(t,
+		eventually(func() bool { return strings.Contains(output.String(), "Reload failed") }),
+		"The reload error should be logged")
+	require.Equal(t, []string{"^/fourth$", "^/fifth$"}, regexes(), "The previous routes should be kept")
+}
+
+// eventually polls the condition for a second and tells if it became true
+func eventually(condition func() bool) bool {
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if condition() {
+			return true
+		}
+	}
+	return false
+}
@@ -1,6 +1,14 @@
 module github.com/mikloslorinczi/mokk
 
+go 1.24
+
 require (
 	github.com/pkg/errors v0.8.1
 	github.com/stretchr/testify v1.3.0
+	gopkg.in/yaml.v2 v2.2.2
+)
+
+require (
+	github.com/davecgh/go-spew v1.1.0 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
 )
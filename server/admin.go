@@ -0,0 +1,184 @@
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Admin is a Handler for runtime stub management of a Router
+// It is served by the Router under its prefix with the following endpoints:
+//
+//	GET    {prefix}routes                      lists the routes and their methods
+//	POST   {prefix}routes                      adds a stub described by a JSON RouteDefinition (without bodyFile)
+//	DELETE {prefix}routes?regex=...&method=... removes a stub, or the whole route without method
+//	GET    {prefix}scenarios                   lists the scenarios and their current states
+//	POST   {prefix}reset                       clears the journal and resets the handlers and scenarios
+//	GET    {prefix}requests                    returns the request journal
+//	DELETE {prefix}requests                    clears the request journal
+type Admin struct {
+	prefix       string
+	router       *Router
+	errorHandler ErrorHandler
+}
+
+// RouteInfo describes a Route in the Admin API
+type RouteInfo struct {
+	Regex   string   `json:"regex"`
+	Methods []string `json:"methods"`
+}
+
+// resetter is implemented by handlers which can reset their state
+type resetter interface {
+	Reset()
+}
+
+// WithAdmin enables the Admin API on the Router under the given path prefix (e.g. /__mokk/) and returns it
+// If the Router has no Journal a new one is added, so the requests can be fetched
+func (router *Router) WithAdmin(prefix string) *Router {
+	router.AddAdmin(prefix)
+	return router
+}
+
+// AddAdmin enables the Admin API on the Router under the given path prefix (e.g. /__mokk/)
+// If the Router has no Journal a new one is added, so the requests can be fetched
+func (router *Router) AddAdmin(prefix string) {
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	if router.journal == nil {
+		router.journal = NewJournal()
+	}
+	router.admin = &Admin{
+		prefix:       prefix,
+		router:       router,
+		errorHandler: &BasicErrorHandler{},
+	}
+}
+
+// Admin returns the Router's Admin API, or nil if it is not enabled
+func (router *Router) Admin() *Admin {
+	return router.admin
+}
+
+// ServeHTTP dispatches the Admin API request by its path and method
+func (admin *Admin) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	endpoint := req.Method + " " + strings.TrimPrefix(req.URL.Path, admin.prefix)
+	switch endpoint {
+	case "GET routes":
+		admin.writeJSON(res, req, http.StatusOK, admin.routes())
+	case "POST routes":
+		admin.addRoute(res, req)
+	case "DELETE routes":
+		admin.removeRoute(res, req)
+	case "GET scenarios":
+		admin.writeJSON(res, req, http.StatusOK, admin.scenarios())
+	case "POST reset":
+		admin.reset()
+		res.WriteHeader(http.StatusNoContent)
+	case "GET requests":
+		admin.writeJSON(res, req, http.StatusOK, admin.router.journal.Requests())
+	case "DELETE requests":
+		admin.router.journal.Reset()
+		res.WriteHeader(http.StatusNoContent)
+	default:
+		admin.errorHandler.HandleError(res, req, http.StatusNotFound, errors.Errorf("Unknown admin endpoint: %s", endpoint))
+	}
+}
+
+// routes returns the RouteInfo of every Route of the Router
+func (admin *Admin) routes() []RouteInfo {
+	routes := admin.router.Routes()
+	infos := make([]RouteInfo, 0, len(routes))
+	for _, route := range routes {
+		infos = append(infos, RouteInfo{Regex: route.Regex(), Methods: route.Methods()})
+	}
+	return infos
+}
+
+// scenarios returns the ScenarioInfo of every Scenario of the Router
+func (admin *Admin) scenarios() []ScenarioInfo {
+	scenarios := admin.router.Scenarios()
+	infos := make([]ScenarioInfo, 0, len(scenarios))
+	for _, scenario := range scenarios {
+		infos = append(infos, ScenarioInfo{Name: scenario.Name(), State: scenario.State()})
+	}
+	return infos
+}
+
+// addRoute adds the stub described by the request's JSON RouteDefinition to the Router
+// The RouteDefinition must not have a body file, its body has to be given inline
+func (admin *Admin) addRoute(res http.ResponseWriter, req *http.Request) {
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		admin.errorHandler.HandleError(res, req, http.StatusInternalServerError, errors.Wrap(err, "Cannot read request body"))
+		return
+	}
+	var definition RouteDefinition
+	if err = decodeStrict(body, &definition); err == nil {
+		err = definition.normalizeMethod()
+	}
+	if err != nil {
+		admin.errorHandler.HandleError(res, req, http.StatusBadRequest, errors.Wrap(err, "Invalid route definition"))
+		return
+	}
+	if definition.Response.BodyFile != "" {
+		// Body files would let any client of the Admin API read the files of the server
+		admin.errorHandler.HandleError(res, req, http.StatusBadRequest, errors.New("Body files are not allowed"))
+		return
+	}
+	route, err := definition.register(admin.router, admin.router.errorHandler, "")
+	if err != nil {
+		admin.errorHandler.HandleError(res, req, http.StatusBadRequest, errors.Wrap(err, "Invalid route definition"))
+		return
+	}
+	admin.writeJSON(res, req, http.StatusCreated, RouteInfo{Regex: route.Regex(), Methods: route.Methods()})
+}
+
+// removeRoute removes the method given in the query from the Route with the regex given in the query
+// The method is converted to upper case, like the methods of the added stubs
+// Without method, or if it was the Route's last method, the whole Route is removed
+func (admin *Admin) removeRoute(res http.ResponseWriter, req *http.Request) {
+	regex, method := req.URL.Query().Get("regex"), strings.ToUpper(req.URL.Query().Get("method"))
+	route := admin.router.Route(regex)
+	if route == nil || (method != "" && !route.RemoveMethod(method)) {
+		admin.errorHandler.HandleError(res, req, http.StatusNotFound, errors.Errorf("No such route: %s %s", method, regex))
+		return
+	}
+	if len(route.Methods()) == 0 || method == "" {
+		admin.router.RemoveRoute(regex)
+	}
+	res.WriteHeader(http.StatusNoContent)
+}
+
+// reset clears the Journal, resets the state of every handler which supports it and every Scenario
+func (admin *Admin) reset() {
+	admin.router.journal.Reset()
+	admin.router.ResetScenarios()
+	for _, route := range admin.router.Routes() {
+		for _, handlers := range route.Handlers() {
+			for _, handler := range handlers {
+				if handler, ok := handler.(resetter); ok {
+					handler.Reset()
+				}
+			}
+		}
+	}
+}
+
+// writeJSON writes the value as a JSON response with the given status
+func (admin *Admin) writeJSON(res http.ResponseWriter, req *http.Request, status int, value interface{}) {
+	body, err := json.Marshal(value)
+	if err != nil {
+		admin.errorHandler.HandleError(res, req, http.StatusInternalServerError, errors.Wrap(err, "Cannot encode response"))
+		return
+	}
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(status)
+	if _, err := res.Write(body); err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// adminRequest sends a request to the Admin API and returns the response status and body
+func adminRequest(t *testing.T, method, url, body string) (int, []byte) {
+	request, err := http.NewRequest(method, url, strings.NewReader(body))
+	require.NoError(t, err, "Admin request should be created")
+	resp, err := http.DefaultClient.Do(request)
+	require.NoError(t, err, "Admin request shouldn't return any errors")
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err, "The response body should be readable")
+	return resp.StatusCode, respBody
+}
+
+func TestAdmin(t *testing.T) {
+	t.Log("Testing Admin API...")
+
+	handler := NewTestHandler(nil).WithResponseSequence(Response{Status: http.StatusAccepted}, Response{})
+	router := NewRouter(nil).
+		WithRoute(MustNewRoute("^/existing$", nil).WithMethod("GET", handler)).
+		WithAdmin("/__mokk")
+	srv := NewServer(router)
+	defer srv.Close()
+	admin := srv.URL + "/__mokk/"
+
+	status, body := adminRequest(t, "POST", admin+"routes",
+		`{"regex": "^/stub$", "method": "POST", "response": {"status": 201, "body": "Created"}}`)
+	require.Equal(t, http.StatusCreated, status, "The stub should be added")
+	require.JSONEq(t, `{"regex": "^/stub$", "methods": ["POST"]}`, string(body), "The added route should be returned")
+
+	status, body = adminRequest(t, "POST", srv.URL+"/stub", "")
+	require.Equal(t, http.StatusCreated, status, "The stub should be served")
+	require.Equal(t, "Created", string(body), "The stub's body should be returned")
+
+	status, body = adminRequest(t, "GET", admin+"routes", "")
+	require.Equal(t, http.StatusOK, status, "The routes should be listed")
+	require.JSONEq(t,
+		`[{"regex": "^/existing$", "methods": ["GET"]}, {"regex": "^/stub$", "methods": ["POST"]}]`,
+		string(body),
+		"All routes should be listed")
+
+	status, _ = adminRequest(t, "GET", srv.URL+"/existing", "")
+	require.Equal(t, http.StatusAccepted, status, "The first response of the sequence should be returned")
+
+	status, body = adminRequest(t, "GET", admin+"requests", "")
+	require.Equal(t, http.StatusOK, status, "The journal should be returned")
+	var requests []map[string]interface{}
+	require.NoError(t, json.Unmarshal(body, &requests), "The journal should be JSON")
+	require.Len(t, requests, 2, "Admin requests should not be recorded")
+	require.Equal(t, "/stub", requests[0]["url"], "The request URL should be recorded")
+	require.Equal(t, "^/existing$", requests[1]["matchedRoute"], "The matched route should be recorded")
+
+	status, _ = adminRequest(t, "POST", admin+"reset", "")
+	require.Equal(t, http.StatusNoContent, status, "The state should be reset")
+	require.Empty(t, router.journal.Requests(), "The journal should be cleared")
+	require.Equal(t, 0, handler.Calls(), "The handler's calls should be reset")
+	status, _ = adminRequest(t, "GET", srv.URL+"/existing", "")
+	require.Equal(t, http.StatusAccepted, status, "The response sequence should be restarted")
+
+	status, _ = adminRequest(t, "DELETE", admin+"requests", "")
+	require.Equal(t, http.StatusNoContent, status, "The journal should be cleared")
+	require.Empty(t, router.journal.Requests(), "The journal should be empty")
+
+	status, _ = adminRequest(t, "DELETE", admin+"routes?regex="+url.QueryEscape("^/stub$")+"&method=post", "")
+	require.Equal(t, http.StatusNoContent, status, "The stub should be removed by its lower case method")
+	require.Nil(t, router.Route("^/stub$"), "The route without methods should be removed")
+	status, _ = adminRequest(t, "DELETE", admin+"routes?regex="+url.QueryEscape("^/existing$"), "")
+	require.Equal(t, http.StatusNoContent, status, "The route should be removed")
+	require.Empty(t, router.Routes(), "All routes should be removed")
+}
+
+func TestAdmin_errors(t *testing.T) {
+	t.Log("Testing Admin API errors...")
+
+	srv := NewServer(NewRouter(nil).WithAdmin("/__mokk/"))
+	defer srv.Close()
+	admin := srv.URL + "/__mokk/"
+
+	for _, request := range []struct {
+		method, path, body string
+		status             int
+	}{
+		{"GET", "unknown", "", http.StatusNotFound},
+		{"POST", "routes", "not json", http.StatusBadRequest},
+		{"POST", "routes", `{"regex": "("}`, http.StatusBadRequest},
+		{"POST", "routes", `{"regex": "^/typo$", "reponse": {"status": 201}}`, http.StatusBadRequest},
+		{"POST", "routes", `{"regex": "^/passwd$", "response": {"bodyFile": "/etc/passwd"}}`, http.StatusBadRequest},
+		{"DELETE", "routes?regex=missing", "", http.StatusNotFound},
+	} {
+		status, _ := adminRequest(t, request.method, admin+request.path, request.body)
+		require.Equalf(t, request.status, status, "%s %s should fail", request.method, request.path)
+	}
+	status, _ := adminRequest(t, "GET", admin+"routes", "")
+	require.Equal(t, http.StatusOK, status, "The routes should be listed")
+	status, _ = adminRequest(t, "GET", srv.URL+"/passwd", "")
+	require.Equal(t, http.StatusNotFound, status, "Stubs with body files should not be added")
+}
+
+func TestAdmin_servers(t *testing.T) {
+	t.Log("Testing the Admin API of Server and TestServer...")
+
+	srv := NewAdminServer(NewRouter(nil), "/__mokk/")
+	defer srv.Close()
+	status, _ := adminRequest(t, "GET", srv.URL+"/__mokk/routes", "")
+	require.Equal(t, http.StatusOK, status, "The Server should serve the Admin API")
+
+	testSrv := NewTestServer(t)
+	testSrv.Admin("/__mokk/")
+	testSrv.Init()
+	defer testSrv.Close()
+	status, _ = adminRequest(t, "POST", testSrv.URL+"/__mokk/routes", `{"regex": "^/stub$"}`)
+	require.Equal(t, http.StatusCreated, status, "The stub should be added to the TestServer")
+	status, _ = adminRequest(t, "GET", testSrv.URL+"/stub", "")
+	require.Equal(t, http.StatusOK, status, "The TestServer should serve the stub")
+}
@@ -0,0 +1,178 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Conditional is implemented by handlers which apply to requests only in certain conditions,
+// so a Route can choose among multiple handlers of the same method
+// Applies returns an error describing why the handler does not apply, or nil if it does
+// A *MismatchError tells the Route how close the handler was to apply
+type Conditional interface {
+	Applies(req *http.Request) error
+}
+
+// Prioritized is implemented by handlers with a priority
+// A Route tries the handlers of a method with higher priority first,
+// the ones with equal priority from the most recently added one
+type Prioritized interface {
+	Priority() int
+}
+
+// MismatchError lists the reasons a handler does not apply to a request,
+// with the HTTP status the mismatch is reported with
+type MismatchError struct {
+	Status  int
+	Reasons []string
+}
+
+// newMismatchError creates a MismatchError with the given status from the non nil errors
+func newMismatchError(status int, errs ...error) *MismatchError {
+	mismatch := &MismatchError{Status: status}
+	for _, err := range errs {
+		if err != nil {
+			mismatch.Reasons = append(mismatch.Reasons, err.Error())
+		}
+	}
+	return mismatch
+}
+
+// Error returns the reasons, one per line
+func (mismatch *MismatchError) Error() string {
+	return strings.Join(mismatch.Reasons, "\n")
+}
+
+// WithPriority sets the priority of the TestHandler among the handlers of its Route's method and returns it
+// Handlers with higher priority are tried first, the default is 0
+func (handler *TestHandler) WithPriority(priority int) *TestHandler {
+	handler.AddPriority(priority)
+	return handler
+}
+
+// AddPriority sets the priority of the TestHandler among the handlers of its Route's method
+// Handlers with higher priority are tried first, the default is 0
+func (handler *TestHandler) AddPriority(priority int) {
+	handler.priority = priority
+}
+
+// Priority returns the priority of the TestHandler
+func (handler *TestHandler) Priority() int {
+	return handler.priority
+}
+
+// Applies returns a *MismatchError listing every requirement of the TestHandler the request does not meet,
+// or nil if the TestHandler applies to the request
+// A mismatching Scenario state is reported with HTTP 404 Not Found, other mismatches as in ServeHTTP
+func (handler *TestHandler) Applies(req *http.Request) error {
+	if mismatch := handler.mismatch(req); mismatch != nil {
+		return mismatch
+	}
+	return nil
+}
+
+// mismatch returns the MismatchError of the request, or nil if the TestHandler applies to it
+func (handler *TestHandler) mismatch(req *http.Request) *MismatchError {
+	if err := handler.checkState(); err != nil {
+		mismatch := newMismatchError(http.StatusNotFound, err)
+		if requestMismatch := handler.checkRequest(req); requestMismatch != nil {
+			mismatch.Reasons = append(mismatch.Reasons, requestMismatch.Reasons...)
+		}
+		return mismatch
+	}
+	return handler.checkRequest(req)
+}
+
+// selectedHandlerKey is the context key of the handler a Route selected for the request
+type selectedHandlerKey struct{}
+
+// withSelectedHandler returns a shallow copy of the request with the selected handler in its context,
+// so the handler does not have to check the request again
+func withSelectedHandler(req *http.Request, handler http.Handler) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), selectedHandlerKey{}, handler))
+}
+
+// selectedHandler returns the handler the Route selected for the request, or nil
+func selectedHandler(req *http.Request) http.Handler {
+	handler, _ := req.Context().Value(selectedHandlerKey{}).(http.Handler)
+	return handler
+}
+
+// priority returns the priority of the handler, or 0 if it is not Prioritized
+func priority(handler http.Handler) int {
+	if prioritized, ok := handler.(Prioritized); ok {
+		return prioritized.Priority()
+	}
+	return 0
+}
+
+// alwaysApplies returns whether the handler applies to every request:
+// it is not Conditional, or it is a TestHandler without requirements on the request
+func alwaysApplies(handler http.Handler) bool {
+	if testHandler, ok := handler.(*TestHandler); ok {
+		return !testHandler.hasRequirements()
+	}
+	_, ok := handler.(Conditional)
+	return !ok
+}
+
+// hasRequirements returns whether the TestHandler has any requirement on the request or the Scenario state
+func (handler *TestHandler) hasRequirements() bool {
+	return handler.requestProto != "" ||
+		len(handler.pathParams) > 0 ||
+		len(handler.queryParams) > 0 ||
+		len(handler.requestHeaders) > 0 ||
+		handler.requestBody != nil ||
+		handler.jsonBody != nil ||
+		len(handler.matchers) > 0 ||
+		handler.requiredState != nil
+}
+
+// candidate is a handler of a Route's method with its position in the order they were added
+type candidate struct {
+	handler  http.Handler
+	position int
+	priority int
+}
+
+// candidates returns the handlers in the order they are tried: by priority, then from the most recently added
+func candidates(handlers []http.Handler) []candidate {
+	ordered := make([]candidate, 0, len(handlers))
+	for i := len(handlers) - 1; i >= 0; i-- {
+		ordered = append(ordered, candidate{handler: handlers[i], position: i + 1, priority: priority(handlers[i])})
+	}
+	sort.SliceStable(ordered, func(i, j int) bool { return ordered[i].priority > ordered[j].priority })
+	return ordered
+}
+
+// applicableHandler returns the first of the Route's handlers which applies to the request, in the order of candidates
+// If none applies the MismatchError lists the closest matches (see rankNearMisses)
+// with the HTTP status of the closest one
+func (route *Route) applicableHandler(handlers []http.Handler, req *http.Request) (http.Handler, *MismatchError) {
+	var misses []nearMiss
+	for _, candidate := range candidates(handlers) {
+		conditional, ok := candidate.handler.(Conditional)
+		if !ok {
+			return candidate.handler, nil
+		}
+		err := conditional.Applies(req)
+		if err == nil {
+			return candidate.handler, nil
+		}
+		misses = append(misses, nearMiss{
+			method:   req.Method,
+			regex:    route.Regex(),
+			handler:  candidate.position,
+			handlers: len(handlers),
+			reasons:  mismatchReasons(err),
+			status:   mismatchStatus(err),
+		})
+	}
+	misses = rankNearMisses(misses)
+	return nil, &MismatchError{
+		Status:  misses[0].status,
+		Reasons: []string{"No handler applies to the request" + formatNearMisses(misses)},
+	}
+}
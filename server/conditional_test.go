@@ -0,0 +1,147 @@
+package server
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// conditionalRequest sends a GET request with the given headers and returns the response status and body
+func conditionalRequest(t *testing.T, url string, headers map[string]string) (int, string) {
+	request, err := http.NewRequest("GET", url, nil)
+	require.NoError(t, err, "Test request should be created")
+	for key, value := range headers {
+		request.Header.Set(key, value)
+	}
+	resp, err := http.DefaultClient.Do(request)
+	require.NoError(t, err, "Test server shouldn't return any errors")
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err, "Response body should be read")
+	return resp.StatusCode, string(body)
+}
+
+func TestConditional(t *testing.T) {
+	t.Log("Testing the choice among multiple conditional handlers...")
+
+	matcherCalls := 0
+	route := MustNewRoute("^/pets$", nil).
+		WithMethod("GET", NewTestHandler(nil).
+			WithRequestHeader("Accept", "application/json").
+			WithMatcher(RequestMatcherFunc(func(req *http.Request) error {
+				matcherCalls++
+				return nil
+			})).
+			WithResponseBody([]byte("json"))).
+		WithMethod("GET", NewTestHandler(nil).
+			WithRequestHeader("Accept", "text/plain").
+			WithQueryParam("format", "short").
+			WithResponseBody([]byte("text")))
+	srv := NewServer(NewRouter(nil).WithRoute(route))
+	defer srv.Close()
+
+	status, body := conditionalRequest(t, srv.URL+"/pets", map[string]string{"Accept": "application/json"})
+	require.Equal(t, http.StatusOK, status, "The matching handler should respond")
+	require.Equal(t, "json", body, "The handler whose requirements pass should respond")
+	require.Equal(t, 1, matcherCalls, "The selected handler should not check the request again")
+
+	status, body = conditionalRequest(t, srv.URL+"/pets?format=short", map[string]string{"Accept": "text/plain"})
+	require.Equal(t, http.StatusOK, status, "The matching handler should respond")
+	require.Equal(t, "text", body, "The handler whose requirements pass should respond")
+
+	status, body = conditionalRequest(t, srv.URL+"/pets", map[string]string{"Accept": "text/plain"})
+	require.Equal(t, http.StatusBadRequest, status, "Requests no handler applies to should return HTTP 400")
+	require.Contains(t, body, "No handler applies to the request\nClosest matches:\n"+
+		"1. GET ^/pets$ (handler 2 of 2)\n\tRequired query params",
+		"The closest match should be reported first")
+	require.Contains(t, body, `query param "format": expected to be present`,
+		"The closest match's mismatches should be reported")
+	require.Contains(t, body, "2. GET ^/pets$ (handler 1 of 2)\n\tRequired headers",
+		"The other handlers should be reported with their mismatches")
+}
+
+func TestConditional_priority(t *testing.T) {
+	t.Log("Testing handler priorities...")
+
+	route := MustNewRoute("^/priority$", nil).
+		WithMethod("GET", NewTestHandler(nil).WithPriority(10).WithResponseStatus(http.StatusAccepted)).
+		WithMethod("GET", NewTestHandler(nil).WithResponseStatus(http.StatusCreated)).
+		WithMethod("GET", NewTestHandler(nil).WithPriority(-1).WithResponseStatus(http.StatusNoContent))
+	srv := NewServer(NewRouter(nil).WithRoute(route))
+	defer srv.Close()
+
+	status, _ := conditionalRequest(t, srv.URL+"/priority", nil)
+	require.Equal(t, http.StatusAccepted, status, "The handler with the highest priority should respond")
+
+	definition, err := ParseDefinition([]byte(`
+routes:
+  - {regex: ^/p$, priority: 1, response: {body: high}}
+  - {regex: ^/p$, response: {body: low}}
+`))
+	require.NoError(t, err, "The definition should be parsed")
+	router, err := definition.Router(nil)
+	require.NoError(t, err, "The Router should be created")
+	definitionSrv := NewServer(router)
+	defer definitionSrv.Close()
+	_, body := conditionalRequest(t, definitionSrv.URL+"/p", nil)
+	require.Equal(t, "high", body, "The definition's priority should be used")
+}
+
+func TestRoute_AddMethod_shadowed(t *testing.T) {
+	t.Log("Testing dropping the handlers shadowed by an unconditional one...")
+
+	important := NewTestHandler(nil).WithPriority(1).WithRequestHeader("X-Important", "yes")
+	conditional := NewTestHandler(nil).WithRequestHeader("X-Conditional", "yes")
+	route := MustNewRoute("^/test$", nil).
+		WithMethod("GET", important).
+		WithMethod("GET", conditional)
+	require.Equal(t, []http.Handler{important, conditional}, route.Handlers()["GET"],
+		"Conditional handlers should be kept")
+
+	unconditional := NewTestHandler(nil)
+	route.AddMethod("GET", unconditional)
+	require.Equal(t, []http.Handler{important, unconditional}, route.Handlers()["GET"],
+		"Unconditional handlers should replace the ones with the same or lower priority")
+
+}
+
+func TestAssertExpectations_shadowed(t *testing.T) {
+	t.Log("Testing the expectations of handlers shadowed by an unconditional handler...")
+
+	srv := NewTestServer(t)
+	srv.Handle("^/x$", "GET", srv.Handler().Once())
+	srv.Handle("^/x$", "GET", srv.Handler())
+	srv.Init()
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/x")
+	require.NoError(t, err, "Test server shouldn't return any errors")
+	resp.Body.Close()
+	require.Equal(t,
+		[]string{"GET ^/x$: expected exactly 1 call(s), got 0"},
+		srv.unmetExpectations(),
+		"The unmet expectation of the shadowed handler should be reported")
+	// The expectation is left unmet on purpose, it must not fail the test when it finishes
+	srv.asserted = true
+}
+
+func TestMismatchError(t *testing.T) {
+	t.Log("Testing MismatchError...")
+
+	handler := NewTestHandler(nil).WithRequestHeader("X-A", "a").WithRequestBody([]byte("body"))
+	request, err := http.NewRequest("POST", "/", strings.NewReader("other"))
+	require.NoError(t, err, "Test request should be created")
+	err = handler.Applies(request)
+	require.Error(t, err, "The handler should not apply")
+	mismatch, ok := err.(*MismatchError)
+	require.True(t, ok, "The error should be a MismatchError")
+	require.Equal(t, http.StatusBadRequest, mismatch.Status, "Request mismatches should be reported with HTTP 400")
+	require.Len(t, mismatch.Reasons, 2, "Every mismatch should be listed")
+
+	request.Header.Set("X-A", "a")
+	request.Body = ioutil.NopCloser(strings.NewReader("body"))
+	require.NoError(t, handler.Applies(request), "The handler should apply")
+}
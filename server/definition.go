@@ -0,0 +1,352 @@
+package server
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Definition is a declarative description of a Router's routes
+// It can be written in YAML or JSON, see ParseDefinition
+type Definition struct {
+	Routes []RouteDefinition `json:"routes" yaml:"routes"`
+
+	// dir is the directory relative body files are resolved from
+	dir string
+}
+
+// RouteDefinition describes a TestHandler registered on a path regex with a method
+// Multiple RouteDefinitions of the same regex and method are tried by Priority (see TestHandler.WithPriority)
+// With a Scenario the TestHandler applies only in its RequiredState (if set),
+// and moves the Scenario to its NewState (if set) after responding
+type RouteDefinition struct {
+	Regex         string             `json:"regex" yaml:"regex"`
+	Method        string             `json:"method,omitempty" yaml:"method,omitempty"`
+	Scenario      string             `json:"scenario,omitempty" yaml:"scenario,omitempty"`
+	RequiredState string             `json:"requiredState,omitempty" yaml:"requiredState,omitempty"`
+	NewState      string             `json:"newState,omitempty" yaml:"newState,omitempty"`
+	Priority      int                `json:"priority,omitempty" yaml:"priority,omitempty"`
+	Request       RequestDefinition  `json:"request" yaml:"request,omitempty"`
+	Response      ResponseDefinition `json:"response" yaml:"response"`
+}
+
+// RequestDefinition describes the requirements on the incoming request
+// JSON is compared semantically with the request body, in subset mode if JSONSubset is true
+// Proto is the required protocol version, like HTTP/2.0
+type RequestDefinition struct {
+	Proto      string      `json:"proto,omitempty" yaml:"proto,omitempty"`
+	Headers    Values      `json:"headers,omitempty" yaml:"headers,omitempty"`
+	Query      Values      `json:"query,omitempty" yaml:"query,omitempty"`
+	Body       *string     `json:"body,omitempty" yaml:"body,omitempty"`
+	JSON       interface{} `json:"json,omitempty" yaml:"json,omitempty"`
+	JSONSubset bool        `json:"jsonSubset,omitempty" yaml:"jsonSubset,omitempty"`
+}
+
+// ResponseDefinition describes the response of the TestHandler
+// The body is either given inline, as base64 in BodyBase64 (for bodies which are not valid UTF-8, like gzip)
+// or read from BodyFile, which is relative to the definition file
+// If Template is true the body is a response template (see TestHandler.WithResponseTemplate)
+type ResponseDefinition struct {
+	Status     int     `json:"status,omitempty" yaml:"status,omitempty"`
+	Headers    Values  `json:"headers,omitempty" yaml:"headers,omitempty"`
+	Body       *string `json:"body,omitempty" yaml:"body,omitempty"`
+	BodyBase64 string  `json:"bodyBase64,omitempty" yaml:"bodyBase64,omitempty"`
+	BodyFile   string  `json:"bodyFile,omitempty" yaml:"bodyFile,omitempty"`
+	Template   bool    `json:"template,omitempty" yaml:"template,omitempty"`
+}
+
+// Values are the header or query values of a definition by their keys
+// A key with a single value can be given a scalar instead of a list, like X-Token: abc
+type Values map[string][]string
+
+// UnmarshalYAML decodes the Values, accepting a scalar or a list for each key
+func (values *Values) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var decoded map[string]valueList
+	if err := unmarshal(&decoded); err != nil {
+		return err
+	}
+	*values = make(Values, len(decoded))
+	for key, list := range decoded {
+		(*values)[key] = list
+	}
+	return nil
+}
+
+// UnmarshalJSON decodes the Values, accepting a string or an array of strings for each key
+func (values *Values) UnmarshalJSON(data []byte) error {
+	var decoded map[string]valueList
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+	*values = make(Values, len(decoded))
+	for key, list := range decoded {
+		(*values)[key] = list
+	}
+	return nil
+}
+
+// valueList is the values of a single key, given as a scalar or a list
+type valueList []string
+
+// UnmarshalYAML decodes a scalar as a single value, or a list of scalars
+func (list *valueList) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var single string
+	if err := unmarshal(&single); err == nil {
+		*list = valueList{single}
+		return nil
+	}
+	var multiple []string
+	if err := unmarshal(&multiple); err != nil {
+		return err
+	}
+	*list = multiple
+	return nil
+}
+
+// UnmarshalJSON decodes a string as a single value, or an array of strings
+func (list *valueList) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*list = valueList{single}
+		return nil
+	}
+	var multiple []string
+	if err := json.Unmarshal(data, &multiple); err != nil {
+		return err
+	}
+	*list = multiple
+	return nil
+}
+
+// methodRegex matches the valid HTTP methods (tokens, see RFC 7230) in upper case
+var methodRegex = regexp.MustCompile("^[!#$%&'*+.^_`|~0-9A-Z-]+$")
+
+// ParseDefinition parses a YAML or JSON (which is also valid YAML) mock definition
+// Unknown fields are rejected, so a misspelled field is not ignored silently
+// Scalar bodies, headers and query values are taken as strings, so body: 42 is the body "42"
+// The methods of the routes are converted to upper case
+func ParseDefinition(data []byte) (*Definition, error) {
+	var definition Definition
+	if err := yaml.UnmarshalStrict(data, &definition); err != nil {
+		return nil, errors.Wrap(err, "Invalid definition")
+	}
+	for i := range definition.Routes {
+		if err := definition.Routes[i].normalizeMethod(); err != nil {
+			return nil, errors.Wrapf(err, "Invalid route %d", i+1)
+		}
+	}
+	return &definition, nil
+}
+
+// decodeStrict decodes the JSON data into the value, returning an error on unknown fields
+func decodeStrict(data []byte, value interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(value)
+}
+
+// LoadDefinitionFile reads and parses a YAML or JSON mock definition file
+func LoadDefinitionFile(path string) (*Definition, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "Cannot read definition file")
+	}
+	definition, err := ParseDefinition(data)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Cannot load definition file %s", path)
+	}
+	definition.dir = filepath.Dir(path)
+	return definition, nil
+}
+
+// WriteDefinitionFile writes the Definition into a file, as JSON if its extension is .json, as YAML otherwise
+func WriteDefinitionFile(path string, definition *Definition) error {
+	var data []byte
+	var err error
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		data, err = json.MarshalIndent(definition, "", "  ")
+	} else {
+		data, err = yaml.Marshal(definition)
+	}
+	if err != nil {
+		return errors.Wrap(err, "Cannot encode definition")
+	}
+	return errors.Wrap(ioutil.WriteFile(path, data, 0644), "Cannot write definition file")
+}
+
+// LoadRouterFromFile creates a new Router with the given ErrorHandler from a mock definition file
+// The ErrorHandler is used by the Router, its Routes and TestHandlers
+func LoadRouterFromFile(path string, errHandler ErrorHandler) (*Router, error) {
+	definition, err := LoadDefinitionFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return definition.Router(errHandler)
+}
+
+// Router creates a new Router with the given ErrorHandler from the Definition
+// Routes with the same regex are merged into a single Route with multiple methods,
+// in the order of their first appearance
+func (definition *Definition) Router(errHandler ErrorHandler) (*Router, error) {
+	router := NewRouter(errHandler)
+	for i, routeDefinition := range definition.Routes {
+		if _, err := routeDefinition.register(router, errHandler, definition.dir); err != nil {
+			return nil, errors.Wrapf(err, "Invalid route %d (%s %s)", i+1, routeDefinition.Method, routeDefinition.Regex)
+		}
+	}
+	return router, nil
+}
+
+// register adds the TestHandler described by the RouteDefinition to the Router and returns its Route
+// Relative body files are resolved from the given directory, Scenarios are the Router's ones
+func (routeDefinition RouteDefinition) register(router *Router, errHandler ErrorHandler, dir string) (*Route, error) {
+	handler, err := routeDefinition.handler(errHandler, dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := routeDefinition.addScenario(router, handler); err != nil {
+		return nil, err
+	}
+	return router.handle(routeDefinition.Regex, routeDefinition.method(), handler)
+}
+
+// addScenario sets the required and new states of the Router's Scenario on the TestHandler
+func (routeDefinition RouteDefinition) addScenario(router *Router, handler *TestHandler) error {
+	if routeDefinition.Scenario == "" {
+		if routeDefinition.RequiredState != "" || routeDefinition.NewState != "" {
+			return errors.New("requiredState and newState need a scenario")
+		}
+		return nil
+	}
+	scenario := router.Scenario(routeDefinition.Scenario)
+	if routeDefinition.RequiredState != "" {
+		handler.AddRequiredState(scenario, routeDefinition.RequiredState)
+	}
+	if routeDefinition.NewState != "" {
+		handler.AddNewState(scenario, routeDefinition.NewState)
+	}
+	return nil
+}
+
+// normalizeMethod converts the RouteDefinition's method to upper case
+// It returns an error if the method is not a valid HTTP method
+func (routeDefinition *RouteDefinition) normalizeMethod() error {
+	routeDefinition.Method = strings.ToUpper(routeDefinition.Method)
+	if routeDefinition.Method != "" && !methodRegex.MatchString(routeDefinition.Method) {
+		return errors.Errorf("Invalid method %q", routeDefinition.Method)
+	}
+	return nil
+}
+
+// method returns the RouteDefinition's method, GET by default
+func (routeDefinition RouteDefinition) method() string {
+	if routeDefinition.Method == "" {
+		return http.MethodGet
+	}
+	return routeDefinition.Method
+}
+
+// handler creates the TestHandler described by the RouteDefinition
+func (routeDefinition RouteDefinition) handler(errHandler ErrorHandler, dir string) (*TestHandler, error) {
+	handler := NewTestHandler(errHandler).WithPriority(routeDefinition.Priority)
+	request, response := routeDefinition.Request, routeDefinition.Response
+	// Request requirements
+	if request.Proto != "" {
+		handler.AddRequestProto(request.Proto)
+	}
+	handler.AddRequestHeaders(request.Headers)
+	handler.AddQueryParams(request.Query)
+	if request.Body != nil {
+		handler.AddRequestBody([]byte(*request.Body))
+	}
+	if request.JSON != nil {
+		body, err := json.Marshal(yamlToJSON(request.JSON))
+		if err != nil {
+			return nil, errors.Wrap(err, "Invalid JSON request body")
+		}
+		handler.jsonBody = newJSONRequirement(body, request.JSONSubset)
+	}
+	// Response
+	if response.Status != 0 && http.StatusText(response.Status) == "" {
+		return nil, errors.Errorf("Invalid response status %d", response.Status)
+	}
+	handler.AddResponseStatus(response.Status)
+	handler.AddResponseHeaders(response.Headers)
+	body, err := response.body(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !response.Template {
+		handler.AddResponseBody(body)
+		return handler, nil
+	}
+	if handler.bodyTemplate, err = handler.newTemplate("body", string(body)); err != nil {
+		return nil, errors.Wrap(err, "Invalid response template")
+	}
+	return handler, nil
+}
+
+// body returns the inline response body, the decoded base64 body or the content of the body file
+func (response ResponseDefinition) body(dir string) ([]byte, error) {
+	given := 0
+	for _, set := range []bool{response.Body != nil, response.BodyBase64 != "", response.BodyFile != ""} {
+		if set {
+			given++
+		}
+	}
+	if given > 1 {
+		return nil, errors.New("Response body, bodyBase64 and bodyFile are mutually exclusive")
+	}
+	if response.Body != nil {
+		return []byte(*response.Body), nil
+	}
+	if response.BodyBase64 != "" {
+		body, err := base64.StdEncoding.DecodeString(response.BodyBase64)
+		if err != nil {
+			return nil, errors.Wrap(err, "Invalid response bodyBase64")
+		}
+		return body, nil
+	}
+	if response.BodyFile == "" {
+		return nil, nil
+	}
+	path := response.BodyFile
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	body, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "Cannot read response body file")
+	}
+	return body, nil
+}
+
+// yamlToJSON converts the map[interface{}]interface{} objects decoded by YAML
+// to map[string]interface{}, so the value can be encoded as JSON
+func yamlToJSON(value interface{}) interface{} {
+	switch typed := value.(type) {
+	case map[interface{}]interface{}:
+		object := make(map[string]interface{}, len(typed))
+		for key, field := range typed {
+			object[fmt.Sprintf("%v", key)] = yamlToJSON(field)
+		}
+		return object
+	case []interface{}:
+		array := make([]interface{}, len(typed))
+		for i, element := range typed {
+			array[i] = yamlToJSON(element)
+		}
+		return array
+	default:
+		return value
+	}
+}
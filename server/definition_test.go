@@ -0,0 +1,137 @@
+package server
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadRouterFromFile(t *testing.T) {
+	t.Log("Testing loading a Router from a definition file...")
+
+	router, err := LoadRouterFromFile("testdata/definition.yml", NewTestErrorHandler(t))
+	require.NoError(t, err, "The definition file should be loaded")
+	require.Len(t, router.Routes(), 2, "Routes with the same regex should be merged")
+	srv := NewServer(router)
+	defer srv.Close()
+
+	request, err := http.NewRequest("GET", srv.URL+"/users/42", nil)
+	require.NoError(t, err, "Test request should be created")
+	request.Header.Set("Auth", "Pass")
+	resp, err := http.DefaultClient.Do(request)
+	require.NoError(t, err, "Test server shouldn't return any errors")
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode, "Response should be HTTP 200 OK")
+	require.Equal(t, "application/json", resp.Header.Get("Content-Type"), "The response headers should be set")
+	respBody, readErr := ioutil.ReadAll(resp.Body)
+	require.NoError(t, readErr, "The response body should be readable")
+	require.Equal(t, "{\"id\": 42, \"name\": \"alice\"}\n", string(respBody), "The body file should be returned")
+
+	request, err = http.NewRequest("PUT", srv.URL+"/users/7?dryRun=true", strings.NewReader(`{"name": "alice", "age": 3}`))
+	require.NoError(t, err, "Test request should be created")
+	putResp, err := http.DefaultClient.Do(request)
+	require.NoError(t, err, "Test server shouldn't return any errors")
+	defer putResp.Body.Close()
+	require.Equal(t, http.StatusAccepted, putResp.StatusCode, "Response should be HTTP 202 Accepted")
+	putBody, readErr := ioutil.ReadAll(putResp.Body)
+	require.NoError(t, readErr, "The response body should be readable")
+	require.Equal(t, `{"id": 7, "name": "alice"}`, string(putBody), "The response template should be rendered")
+
+	healthResp, err := http.Get(srv.URL + "/health")
+	require.NoError(t, err, "Test server shouldn't return any errors")
+	defer healthResp.Body.Close()
+	require.Equal(t, http.StatusOK, healthResp.StatusCode, "The method should default to GET")
+}
+
+func TestParseDefinition(t *testing.T) {
+	t.Log("Testing parsing definitions...")
+
+	definition, err := ParseDefinition([]byte(`{"routes": [{"regex": "^/test$", "method": "POST"}]}`))
+	require.NoError(t, err, "JSON definitions should be parsed")
+	require.Equal(t, "POST", definition.Routes[0].Method, "The route should be parsed")
+
+	definition, err = ParseDefinition([]byte("routes: [{regex: '^/test$', method: delete}]"))
+	require.NoError(t, err, "Lower case methods should be parsed")
+	require.Equal(t, "DELETE", definition.Routes[0].Method, "The method should be converted to upper case")
+
+	for _, invalid := range []string{
+		"routes: {",
+		"routes: [{regex: '^/$', reponse: {body: a}}]",
+		"routes: [{regex: '^/$', response: {bodyFiles: a.json}}]",
+		"routes: [{regex: '^/$', method: 'GET /'}]",
+	} {
+		_, err = ParseDefinition([]byte(invalid))
+		require.Errorf(t, err, "The definition should not be parsed: %s", invalid)
+	}
+
+	for _, invalid := range []string{
+		"routes: [{regex: '('}]",
+		"routes: [{regex: '^/$', response: {status: 1000}}]",
+		"routes: [{regex: '^/$', response: {template: true, body: '{{'}}]",
+		"routes: [{regex: '^/$', response: {body: a, bodyFile: b}}]",
+		"routes: [{regex: '^/$', response: {body: a, bodyBase64: YQ==}}]",
+		"routes: [{regex: '^/$', response: {bodyBase64: '!'}}]",
+		"routes: [{regex: '^/$', response: {bodyFile: missing.json}}]",
+	} {
+		definition, err := ParseDefinition([]byte(invalid))
+		require.NoErrorf(t, err, "The definition should be parsed: %s", invalid)
+		_, err = definition.Router(nil)
+		require.Errorf(t, err, "The Router should not be created: %s", invalid)
+	}
+
+	_, err = LoadRouterFromFile("testdata/missing.yml", nil)
+	require.Error(t, err, "Missing files should fail")
+}
+
+func TestParseDefinition_scalars(t *testing.T) {
+	t.Log("Testing parsing definitions with scalar bodies, headers and query values...")
+
+	definition, err := ParseDefinition([]byte(`
+routes:
+  - regex: ^/number$
+    request: {body: 42}
+    response: {body: 42}
+  - regex: ^/boolean$
+    request: {query: {dry: [true]}}
+    response: {body: yes}
+  - regex: ^/header$
+    request: {headers: {X-A: b}}
+    response: {headers: {X-Count: 3}}
+`))
+	require.NoError(t, err, "Scalar values should be parsed")
+	require.Equal(t, "42", *definition.Routes[0].Request.Body, "Numeric bodies should be strings")
+	require.Equal(t, "42", *definition.Routes[0].Response.Body, "Numeric bodies should be strings")
+	require.Equal(t, Values{"dry": {"true"}}, definition.Routes[1].Request.Query, "Boolean values should be strings")
+	require.Equal(t, "yes", *definition.Routes[1].Response.Body, "Boolean bodies should keep their text")
+	require.Equal(t, Values{"X-A": {"b"}}, definition.Routes[2].Request.Headers, "Scalar headers should be single values")
+	require.Equal(t, Values{"X-Count": {"3"}}, definition.Routes[2].Response.Headers, "Scalar headers should be single values")
+
+	definition, err = ParseDefinition([]byte(`{"routes": [{"regex": "^/a$", "request": {"headers": {"X-A": "b"}}}]}`))
+	require.NoError(t, err, "Scalar headers should be parsed from JSON")
+	require.Equal(t, Values{"X-A": {"b"}}, definition.Routes[0].Request.Headers, "Scalar headers should be single values")
+
+	definition, err = ParseDefinition([]byte("routes: [{regex: '^/a$', method: post, request: {body: 42, headers: {X-A: b}, query: {dry: true}}, response: {body: yes}}]"))
+	require.NoError(t, err, "The definition should be parsed")
+	srv := NewServer(mustRouter(t, definition))
+	defer srv.Close()
+	request, err := http.NewRequest("POST", srv.URL+"/a?dry=true", strings.NewReader("42"))
+	require.NoError(t, err, "Test request should be created")
+	request.Header.Set("X-A", "b")
+	resp, err := http.DefaultClient.Do(request)
+	require.NoError(t, err, "Test server shouldn't return any errors")
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode, "The scalar requirements should match")
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err, "The response body should be readable")
+	require.Equal(t, "yes", string(body), "The scalar body should be returned")
+}
+
+// mustRouter creates the Router of the Definition, failing the test on error
+func mustRouter(t *testing.T, definition *Definition) *Router {
+	router, err := definition.Router(NewTestErrorHandler(t))
+	require.NoError(t, err, "The Router should be created")
+	return router
+}
@@ -0,0 +1,134 @@
+package server
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Delay tells how long a TestHandler waits before responding to its nth (1-based) call
+type Delay interface {
+	Duration(call int) time.Duration
+}
+
+// fixedDelay is a Delay with the same duration on every call
+type fixedDelay time.Duration
+
+// FixedDelay returns a Delay which waits the given duration on every call
+func FixedDelay(duration time.Duration) Delay {
+	return fixedDelay(duration)
+}
+
+// Duration returns the fixed duration
+func (delay fixedDelay) Duration(call int) time.Duration {
+	return time.Duration(delay)
+}
+
+// randomDelay is a Delay with uniformly distributed random durations
+type randomDelay struct {
+	min    time.Duration
+	max    time.Duration
+	random *rand.Rand
+	mutex  sync.Mutex
+}
+
+// RandomDelay returns a Delay which waits a uniformly random duration between min and max on every call
+// The same seed produces the same sequence of durations
+func RandomDelay(min, max time.Duration, seed int64) Delay {
+	return &randomDelay{
+		min:    min,
+		max:    max,
+		random: rand.New(rand.NewSource(seed)),
+	}
+}
+
+// Duration returns the next random duration
+func (delay *randomDelay) Duration(call int) time.Duration {
+	if delay.max <= delay.min {
+		return delay.min
+	}
+	delay.mutex.Lock()
+	defer delay.mutex.Unlock()
+	return delay.min + time.Duration(delay.random.Int63n(int64(delay.max-delay.min)+1))
+}
+
+// sequenceDelay is a Delay with a different duration on every call
+type sequenceDelay []time.Duration
+
+// SequenceDelay returns a Delay which waits the nth duration on the nth call
+// and the last duration after the sequence is exhausted
+func SequenceDelay(durations ...time.Duration) Delay {
+	return sequenceDelay(durations)
+}
+
+// Duration returns the duration of the given call
+func (delay sequenceDelay) Duration(call int) time.Duration {
+	if len(delay) == 0 {
+		return 0
+	}
+	if call > len(delay) {
+		return delay[len(delay)-1]
+	}
+	return delay[call-1]
+}
+
+// WithDelay sets a Delay the TestHandler waits before writing the response and returns it
+func (handler *TestHandler) WithDelay(delay Delay) *TestHandler {
+	handler.delay = delay
+	return handler
+}
+
+// AddDelay sets a Delay the TestHandler waits before writing the response
+func (handler *TestHandler) AddDelay(delay Delay) {
+	handler.delay = delay
+}
+
+// WithStallAfterHeaders sets a duration the TestHandler waits after writing (and flushing)
+// the response headers and before writing the body, and returns it
+func (handler *TestHandler) WithStallAfterHeaders(duration time.Duration) *TestHandler {
+	handler.stall = duration
+	return handler
+}
+
+// AddStallAfterHeaders sets a duration the TestHandler waits after writing (and flushing)
+// the response headers and before writing the body
+func (handler *TestHandler) AddStallAfterHeaders(duration time.Duration) {
+	handler.stall = duration
+}
+
+// WithHang sets the TestHandler to never respond, only return when the request's context is cancelled,
+// and returns it
+func (handler *TestHandler) WithHang() *TestHandler {
+	handler.hang = true
+	return handler
+}
+
+// AddHang sets the TestHandler to never respond, only return when the request's context is cancelled
+func (handler *TestHandler) AddHang() {
+	handler.hang = true
+}
+
+// sleep waits the given duration or until the context is done
+// It returns false if the context is done before the duration elapsed
+func sleep(ctx context.Context, duration time.Duration) bool {
+	if duration <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// flush sends any buffered data of the response to the client, if the ResponseWriter supports it
+func flush(res http.ResponseWriter) {
+	if flusher, ok := res.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
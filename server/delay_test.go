@@ -0,0 +1,112 @@
+package server
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDelays(t *testing.T) {
+	t.Log("Testing delay durations...")
+
+	require.Equal(t, 10*time.Millisecond, FixedDelay(10*time.Millisecond).Duration(3), "Fixed delays should not change")
+
+	sequence := SequenceDelay(time.Millisecond, 2*time.Millisecond)
+	require.Equal(t, time.Millisecond, sequence.Duration(1), "The first call should get the first delay")
+	require.Equal(t, 2*time.Millisecond, sequence.Duration(2), "The second call should get the second delay")
+	require.Equal(t, 2*time.Millisecond, sequence.Duration(5), "The last delay should be repeated")
+
+	first, second := RandomDelay(time.Millisecond, time.Second, 42), RandomDelay(time.Millisecond, time.Second, 42)
+	for call := 1; call <= 10; call++ {
+		duration := first.Duration(call)
+		require.Equal(t, duration, second.Duration(call), "The same seed should produce the same delays")
+		require.True(t, duration >= time.Millisecond && duration <= time.Second, "The delay should be in range")
+	}
+}
+
+func TestDelay(t *testing.T) {
+	t.Log("Testing response delay...")
+
+	srv := NewTestServer(t)
+	srv.Handle("^/test/delay$", "GET", srv.Handler().WithDelay(FixedDelay(50*time.Millisecond)))
+	srv.Init()
+	defer srv.Close()
+
+	start := time.Now()
+	resp, err := http.Get(srv.URL + "/test/delay")
+	require.NoError(t, err, "Test server shouldn't return any errors")
+	defer resp.Body.Close()
+	require.True(t, time.Since(start) >= 50*time.Millisecond, "The response should be delayed")
+}
+
+func TestDelay_client_timeout(t *testing.T) {
+	t.Log("Testing client timeout against delayed and hanging handlers...")
+
+	srv := NewTestServer(t)
+	srv.Handle("^/test/delay$", "GET", srv.Handler().WithDelay(FixedDelay(time.Minute)))
+	srv.Handle("^/test/hang$", "GET", srv.Handler().WithHang())
+	srv.Init()
+	defer srv.Close()
+
+	client := http.Client{Timeout: 50 * time.Millisecond}
+	for _, path := range []string{"/test/delay", "/test/hang"} {
+		resp, err := client.Get(srv.URL + path)
+		if resp != nil {
+			resp.Body.Close()
+		}
+		require.Errorf(t, err, "The client should time out on %s", path)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	request, err := http.NewRequest("GET", srv.URL+"/test/hang", nil)
+	require.NoError(t, err, "Test request should be created")
+	done := make(chan error)
+	go func() {
+		resp, doErr := http.DefaultClient.Do(request.WithContext(ctx))
+		if resp != nil {
+			resp.Body.Close()
+		}
+		done <- doErr
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	require.Error(t, <-done, "The request should be cancelled")
+
+	closed := make(chan struct{})
+	go func() {
+		srv.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Closing the server should not wait for hanging handlers")
+	}
+}
+
+func TestStallAfterHeaders(t *testing.T) {
+	t.Log("Testing stall after headers...")
+
+	handler := NewTestHandler(nil).
+		WithResponseStatus(http.StatusAccepted).
+		WithResponseBody([]byte("Stalled")).
+		WithStallAfterHeaders(200 * time.Millisecond)
+	srv := NewServer(handler)
+	defer srv.Close()
+
+	start := time.Now()
+	resp, err := http.Get(srv.URL)
+	require.NoError(t, err, "Test server shouldn't return any errors")
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusAccepted, resp.StatusCode, "The status should arrive before the stall")
+	require.True(t, time.Since(start) < 200*time.Millisecond, "The headers should not be stalled")
+
+	respBody, readErr := ioutil.ReadAll(resp.Body)
+	require.NoError(t, readErr, "The response body should be readable")
+	require.Equal(t, []byte("Stalled"), respBody, "The body should arrive after the stall")
+	require.True(t, time.Since(start) >= 200*time.Millisecond, "The body should be stalled")
+}
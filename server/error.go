@@ -2,7 +2,9 @@ package server
 
 import (
 	"fmt"
+	"log"
 	"net/http"
+	"os"
 	"testing"
 )
 
@@ -44,3 +46,26 @@ func NewTestErrorHandler(t *testing.T) *TestErrorHandler {
 func (h *TestErrorHandler) HandleError(res http.ResponseWriter, req *http.Request, status int, err error) {
 	h.T.Errorf("HTTP response status: %d Error: %s", status, err)
 }
+
+// LogErrorHandler is an error handler for long running servers
+// It logs the error with the request's method and URL, then writes it on the response like the BasicErrorHandler
+type LogErrorHandler struct {
+	Logger *log.Logger
+}
+
+// NewLogErrorHandler creates a LogErrorHandler with the given Logger and returns its pointer
+// If nil Logger is provided it will fall back to a Logger writing to the standard error
+func NewLogErrorHandler(logger *log.Logger) *LogErrorHandler {
+	if logger == nil {
+		logger = log.New(os.Stderr, "", log.LstdFlags)
+	}
+	return &LogErrorHandler{
+		Logger: logger,
+	}
+}
+
+// HandleError logs the error then writes the status code and the error on the response
+func (h *LogErrorHandler) HandleError(res http.ResponseWriter, req *http.Request, status int, err error) {
+	h.Logger.Printf("%s %s: HTTP response status: %d Error: %s", req.Method, req.URL, status, err)
+	(&BasicErrorHandler{}).HandleError(res, req, status, err)
+}
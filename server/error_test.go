@@ -1,6 +1,8 @@
 package server
 
 import (
+	"bytes"
+	"log"
 	"net/http"
 	"testing"
 
@@ -9,7 +11,8 @@ import (
 
 func TestBasicErrorHandler_NoError(t *testing.T) {
 	handler := NewTestHandler(nil)
-	route := NewRoute("/test/error$", nil)
+	route, routeErr := NewRoute("/test/error$", nil)
+	require.NoError(t, routeErr, "The route should be created")
 	route.AddMethod("GET", handler)
 	router := NewRouter(nil)
 	router.AddRoute(route)
@@ -33,7 +36,8 @@ func TestBasicErrorHandler_NotFound(t *testing.T) {
 
 func TestBasicErrorHandler_MethodNotAllowed(t *testing.T) {
 	handler := NewTestHandler(nil)
-	route := NewRoute("/existing/path$", nil)
+	route, routeErr := NewRoute("/existing/path$", nil)
+	require.NoError(t, routeErr, "The route should be created")
 	route.AddMethod("PUT", handler)
 	router := NewRouter(nil)
 	router.AddRoute(route)
@@ -45,3 +49,18 @@ func TestBasicErrorHandler_MethodNotAllowed(t *testing.T) {
 	defer resp.Body.Close()
 	require.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode, "Status should be 405 Method Not Allowed")
 }
+
+func TestLogErrorHandler(t *testing.T) {
+	var logs bytes.Buffer
+	server := NewServer(NewRouter(NewLogErrorHandler(log.New(&logs, "", 0))))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/non/existent/path")
+	require.NoError(t, err, "GET shouldn't return any errors")
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusNotFound, resp.StatusCode, "Status should be 404 Not Found")
+	require.Equal(t,
+		"GET /non/existent/path: HTTP response status: 404 Error: Not found: /non/existent/path\n",
+		logs.String(),
+		"The error should be logged")
+}
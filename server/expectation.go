@@ -0,0 +1,100 @@
+package server
+
+import (
+	"github.com/pkg/errors"
+)
+
+// unlimitedCalls marks an expectation without an upper bound
+const unlimitedCalls = -1
+
+// expectation describes how many times a TestHandler expects to be called
+// if set is false the TestHandler accepts any number of calls
+type expectation struct {
+	set      bool
+	minCalls int
+	maxCalls int
+}
+
+// check returns an error if the given number of calls does not satisfy the expectation
+func (exp expectation) check(calls int) error {
+	if !exp.set {
+		return nil
+	}
+	switch {
+	case exp.maxCalls == unlimitedCalls && calls < exp.minCalls:
+		return errors.Errorf("expected at least %d call(s), got %d", exp.minCalls, calls)
+	case exp.maxCalls != unlimitedCalls && (calls < exp.minCalls || calls > exp.maxCalls):
+		return errors.Errorf("expected exactly %d call(s), got %d", exp.maxCalls, calls)
+	}
+	return nil
+}
+
+// Once sets the TestHandler to expect exactly one call and returns it
+func (handler *TestHandler) Once() *TestHandler {
+	return handler.Times(1)
+}
+
+// Times sets the TestHandler to expect exactly n calls and returns it
+func (handler *TestHandler) Times(n int) *TestHandler {
+	handler.mutex.Lock()
+	defer handler.mutex.Unlock()
+	handler.expectation = expectation{set: true, minCalls: n, maxCalls: n}
+	return handler
+}
+
+// AtLeast sets the TestHandler to expect n or more calls and returns it
+func (handler *TestHandler) AtLeast(n int) *TestHandler {
+	handler.mutex.Lock()
+	defer handler.mutex.Unlock()
+	handler.expectation = expectation{set: true, minCalls: n, maxCalls: unlimitedCalls}
+	return handler
+}
+
+// Never sets the TestHandler to expect no calls at all and returns it
+func (handler *TestHandler) Never() *TestHandler {
+	return handler.Times(0)
+}
+
+// Calls returns the number of requests the TestHandler has received so far
+func (handler *TestHandler) Calls() int {
+	handler.mutex.Lock()
+	defer handler.mutex.Unlock()
+	return handler.calls
+}
+
+// CheckExpectations returns an error if the TestHandler's call count expectation is not met
+// A TestHandler without any expectation always passes
+func (handler *TestHandler) CheckExpectations() error {
+	handler.mutex.Lock()
+	defer handler.mutex.Unlock()
+	return handler.expectation.check(handler.calls)
+}
+
+// Reset sets the number of calls of the TestHandler back to zero, which also restarts its response sequence
+// and the counter template helper. The expectation is kept
+func (handler *TestHandler) Reset() {
+	handler.mutex.Lock()
+	handler.calls = 0
+	handler.mutex.Unlock()
+	handler.templateState.mutex.Lock()
+	handler.templateState.counter = 0
+	handler.templateState.mutex.Unlock()
+}
+
+// countCall registers an incoming request on the TestHandler and returns its 1-based number
+func (handler *TestHandler) countCall() int {
+	handler.mutex.Lock()
+	defer handler.mutex.Unlock()
+	handler.calls++
+	return handler.calls
+}
+
+// expecter is implemented by handlers that can verify their own call count expectations
+type expecter interface {
+	CheckExpectations() error
+}
+
+// validated is implemented by handlers that report their invalid options (see TestHandler.Err)
+type validated interface {
+	Err() error
+}
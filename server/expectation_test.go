@@ -0,0 +1,59 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpectations(t *testing.T) {
+	t.Log("Testing call count expectations...")
+
+	handler := NewTestHandler(nil)
+	require.NoError(t, handler.CheckExpectations(), "A TestHandler without expectations should always pass")
+
+	handler.Once()
+	require.Error(t, handler.CheckExpectations(), "Once should fail without any calls")
+	handler.countCall()
+	require.NoError(t, handler.CheckExpectations(), "Once should pass after one call")
+	handler.countCall()
+	require.Error(t, handler.CheckExpectations(), "Once should fail after two calls")
+
+	handler.AtLeast(2)
+	require.NoError(t, handler.CheckExpectations(), "AtLeast(2) should pass after two calls")
+	handler.countCall()
+	require.NoError(t, handler.CheckExpectations(), "AtLeast(2) should pass after three calls")
+	require.Equal(t, 3, handler.Calls(), "The TestHandler should count three calls")
+
+	require.NoError(t, NewTestHandler(nil).Never().CheckExpectations(), "Never should pass without any calls")
+	require.Error(t, NewTestHandler(nil).Times(2).CheckExpectations(), "Times(2) should fail without any calls")
+}
+
+func TestAssertExpectations(t *testing.T) {
+	t.Log("Testing TestServer's AssertExpectations...")
+
+	srv := NewTestServer(t)
+	srv.Handle("/test/once$", "GET", srv.Handler().Once())
+	srv.Handle("/test/never$", "GET", srv.Handler().Never())
+	srv.Handle("/test/twice$", "GET", srv.Handler().Times(2))
+	srv.Init()
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/test/once")
+	require.NoError(t, err, "Test server shouldn't return any errors")
+	defer resp.Body.Close()
+
+	unmet := srv.unmetExpectations()
+	require.Equal(t,
+		[]string{"GET /test/twice$: expected exactly 2 call(s), got 0"},
+		unmet,
+		"Only the route called fewer times than expected should be listed")
+
+	for i := 0; i < 2; i++ {
+		resp, err := http.Get(srv.URL + "/test/twice")
+		require.NoError(t, err, "Test server shouldn't return any errors")
+		resp.Body.Close()
+	}
+	require.True(t, srv.AssertExpectations(), "All expectations should be met")
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+// Fault is a network level failure a TestHandler can simulate instead of a proper response
+// Faults are written directly on the hijacked connection, so the ResponseWriter must be an http.Hijacker
+type Fault int
+
+const (
+	// NoFault writes a proper response
+	NoFault Fault = iota
+	// ConnectionReset abruptly closes the connection without writing anything
+	ConnectionReset
+	// TruncatedBody sends a Content-Length larger than the body, then closes the connection
+	TruncatedBody
+	// MalformedResponse writes garbage instead of a status line
+	MalformedResponse
+	// PartialChunked starts a chunked response body, then closes the connection before the last chunk
+	PartialChunked
+)
+
+// truncatedBodyExtra is the number of bytes TruncatedBody promises in addition to the actual body
+const truncatedBodyExtra = 16
+
+// WithFault sets a Fault the TestHandler simulates instead of writing its response and returns it
+func (handler *TestHandler) WithFault(fault Fault) *TestHandler {
+	handler.fault = fault
+	return handler
+}
+
+// AddFault sets a Fault the TestHandler simulates instead of writing its response
+func (handler *TestHandler) AddFault(fault Fault) {
+	handler.fault = fault
+}
+
+// injectFault hijacks the connection and simulates the TestHandler's Fault with the given response
+func (handler *TestHandler) injectFault(res http.ResponseWriter, req *http.Request, response Response) {
+	hijacker, ok := res.(http.Hijacker)
+	if !ok {
+		handler.errorHandler.HandleError(
+			res,
+			req,
+			http.StatusInternalServerError,
+			errors.New("Cannot inject fault: the ResponseWriter does not support hijacking"))
+		return
+	}
+	conn, buffer, err := hijacker.Hijack()
+	if err != nil {
+		handler.errorHandler.HandleError(
+			res,
+			req,
+			http.StatusInternalServerError,
+			errors.Wrap(err, "Cannot inject fault: failed to hijack the connection"))
+		return
+	}
+	defer conn.Close()
+	switch handler.fault {
+	case ConnectionReset:
+		if tcpConn, ok := conn.(*net.TCPConn); ok {
+			// Discard unsent data and send RST instead of FIN on Close
+			_ = tcpConn.SetLinger(0)
+		}
+		return
+	case TruncatedBody:
+		writeRawHead(buffer, response, handler.responseHeaders, "Content-Length", len(response.Body)+truncatedBodyExtra)
+		_, _ = buffer.Write(response.Body)
+	case MalformedResponse:
+		_, _ = buffer.WriteString("NOT/HTTP garbage\r\n\x00\x01\x02\r\n\r\n")
+	case PartialChunked:
+		writeRawHead(buffer, response, handler.responseHeaders, "Transfer-Encoding", "chunked")
+		half := response.Body[:len(response.Body)/2]
+		if len(half) > 0 {
+			_, _ = fmt.Fprintf(buffer, "%x\r\n%s\r\n", len(half), half)
+		}
+	}
+	_ = buffer.Flush()
+}
+
+// writeRawHead writes the status line and headers of the response directly on the connection
+// with one extra framing header
+func writeRawHead(buffer *bufio.ReadWriter, response Response, headers http.Header, key string, value interface{}) {
+	status := http.StatusOK
+	if response.Status != 0 {
+		status = response.Status
+	}
+	_, _ = fmt.Fprintf(buffer, "HTTP/1.1 %d %s\r\n", status, http.StatusText(status))
+	_ = headers.Write(buffer)
+	_ = response.Headers.Write(buffer)
+	_, _ = fmt.Fprintf(buffer, "%s: %v\r\nConnection: close\r\n\r\n", key, value)
+}
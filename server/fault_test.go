@@ -0,0 +1,56 @@
+package server
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFaults(t *testing.T) {
+	t.Log("Testing fault injection...")
+
+	srv := NewTestServer(t)
+	srv.Handle("^/test/reset$", "GET", srv.Handler().WithFault(ConnectionReset))
+	srv.Handle("^/test/malformed$", "GET", srv.Handler().WithFault(MalformedResponse))
+	srv.Handle("^/test/truncated$", "GET", srv.Handler().
+		WithResponseBody([]byte("Truncated body")).
+		WithFault(TruncatedBody))
+	srv.Handle("^/test/chunked$", "GET", srv.Handler().
+		WithResponseHeader("Route", "chunked").
+		WithResponseBody([]byte("Partial chunked body")).
+		WithFault(PartialChunked))
+	srv.Init()
+	defer srv.Close()
+
+	for _, path := range []string{"/test/reset", "/test/malformed"} {
+		resp, err := http.Get(srv.URL + path)
+		if resp != nil {
+			resp.Body.Close()
+		}
+		require.Errorf(t, err, "The request to %s should fail", path)
+	}
+
+	for _, path := range []string{"/test/truncated", "/test/chunked"} {
+		resp, err := http.Get(srv.URL + path)
+		require.NoErrorf(t, err, "The response headers of %s should be received", path)
+		_, readErr := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		require.Errorf(t, readErr, "Reading the response body of %s should fail", path)
+		require.Equal(t, http.StatusOK, resp.StatusCode, "The status should be received")
+		if path == "/test/chunked" {
+			require.Equal(t, "chunked", resp.Header.Get("Route"), "The headers should be received")
+		}
+	}
+}
+
+func TestFaults_no_hijacker(t *testing.T) {
+	t.Log("Testing fault injection without hijacking support...")
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest("GET", "/", nil)
+	NewTestHandler(nil).WithFault(ConnectionReset).ServeHTTP(recorder, request)
+	require.Equal(t, http.StatusInternalServerError, recorder.Code, "Response should be HTTP 500 Internal Server Error")
+}
@@ -4,6 +4,10 @@ import (
 	"bytes"
 	"io/ioutil"
 	"net/http"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
 
 	"github.com/pkg/errors"
 )
@@ -13,14 +17,60 @@ import (
 // the TestHandler's ErrorHandler will be called with that error
 type TestHandler struct {
 	// Required properties
+	requestProto   string
+	pathParams     map[string]string
+	queryParams    []queryRequirement
 	requestHeaders http.Header
 	requestBody    []byte
+	jsonBody       *jsonRequirement
+	matchers       []RequestMatcher
 
 	// Response properties
 	responseStatus  int
 	responseHeaders http.Header
 	responseBody    []byte
 
+	// Response templates
+	bodyTemplate    *template.Template
+	headerTemplates []headerTemplate
+	templateState   templateState
+
+	// Response sequence
+	responses []Response
+	exhausted ExhaustedBehavior
+
+	// Timing
+	delay Delay
+	stall time.Duration
+	hang  bool
+
+	// Streaming response body
+	chunkSize      int
+	chunkInterval  time.Duration
+	bytesPerSecond int
+
+	// Network level failure
+	fault Fault
+
+	// Server-Sent Events
+	events    []Event
+	streamEnd StreamEnd
+
+	// Scenario states
+	requiredState *scenarioState
+	newState      *scenarioState
+
+	// Priority among the handlers of the Route's method
+	priority int
+
+	// Call count expectation
+	expectation expectation
+	calls       int
+	mutex       sync.Mutex
+
+	// Errors of the invalid options set on the TestHandler (see Err)
+	invalidOptions []string
+
 	errorHandler ErrorHandler
 }
 
@@ -32,77 +82,213 @@ func NewTestHandler(errHandler ErrorHandler) *TestHandler {
 		handler = errHandler
 	}
 	return &TestHandler{
+		pathParams:      make(map[string]string),
 		requestHeaders:  make(http.Header),
 		responseHeaders: make(http.Header),
 		errorHandler:    handler,
 	}
 }
 
+// Err returns an error listing every invalid option set on the TestHandler, or nil if all of them are valid
+// Options are validated when they are set, but reported only here and on every request the TestHandler serves,
+// so the builder methods can be chained
+func (handler *TestHandler) Err() error {
+	if len(handler.invalidOptions) == 0 {
+		return nil
+	}
+	return errors.Errorf("Invalid TestHandler options:\n%s", strings.Join(handler.invalidOptions, "\n"))
+}
+
+// invalidOption records the error of an invalid option, see Err
+func (handler *TestHandler) invalidOption(err error) {
+	handler.invalidOptions = append(handler.invalidOptions, err.Error())
+}
+
 // ServeHTTP
-// The test handler will check (in order) for required request headers and body
-// and call the ErrorHandler if and of them mismatches.
-// Then it will write the response headers, status and body
+// The test handler will count the call, call the ErrorHandler with HTTP 500 Internal Server Error
+// if it has invalid options (see Err), then check for the required Scenario state, protocol version, path parameters,
+// query parameters, request headers, body and custom RequestMatchers
+// and call the ErrorHandler with every mismatch if any of them mismatches
+// (unless its Route has already checked them, see Conditional).
+// Then it will wait its Delay (or hang until the request is cancelled)
+// and write the response headers, status and body (in chunks, if it streams it),
+// stream its Events or simulate its Fault
+// (the next one of the response sequence, if the TestHandler has one, rendered with its response templates)
+// Waiting stops as soon as the request's context is cancelled, in this case nothing more is written
+// After responding the TestHandler moves its Scenario to the new state, if it has one
 func (handler *TestHandler) ServeHTTP(res http.ResponseWriter, req *http.Request) {
-	// Check request headers
-	if !containsAll(handler.requestHeaders, req.Header) {
-		handler.errorHandler.HandleError(
-			res,
-			req,
-			http.StatusBadRequest,
-			errors.Errorf(
-				"Required headers does not match with the actual headers.\nRequired:\n%+v\nActual:\n%+v\n",
-				handler.requestHeaders,
-				req.Header))
+	call := handler.countCall()
+	if err := handler.Err(); err != nil {
+		handler.errorHandler.HandleError(res, req, http.StatusInternalServerError, err)
 		return
 	}
-	// Check request body
-	reqBody, err := ioutil.ReadAll(req.Body)
+	if selectedHandler(req) != handler {
+		if mismatch := handler.mismatch(req); mismatch != nil {
+			handler.errorHandler.HandleError(res, req, mismatch.Status, mismatch)
+			return
+		}
+	}
+	response, err := handler.nextResponse(call)
+	if err == nil {
+		response, err = handler.renderTemplates(req, response, call)
+	}
 	if err != nil {
-		handler.errorHandler.HandleError(
-			res,
-			req,
-			http.StatusInternalServerError,
-			errors.Wrap(err, "Cannot read request body"),
-		)
+		handler.errorHandler.HandleError(res, req, http.StatusInternalServerError, err)
 		return
 	}
-	if handler.requestBody != nil && !bytes.Equal(reqBody, handler.requestBody) {
-		handler.errorHandler.HandleError(
-			res,
-			req,
-			http.StatusBadRequest,
-			errors.Errorf(
-				"Required request body does not match with the actual request body.\nRequired:\n%s\nActual:\n%s\n",
-				handler.requestBody,
-				reqBody))
+	if handler.hang {
+		<-req.Context().Done()
+		return
+	}
+	if handler.delay != nil && !sleep(req.Context(), handler.delay.Duration(call)) {
 		return
 	}
-	// Write response headers
-	if len(handler.responseHeaders) > 0 {
-		for key, value := range handler.responseHeaders {
+	handler.respond(res, req, response)
+}
+
+// respond writes the response, streams the Events or simulates the Fault,
+// then moves the Scenario to the new state and drops the connection of the event stream if it has to
+// If the client cancels the response or the event stream, the Scenario is not moved
+// and the connection is left to the client
+func (handler *TestHandler) respond(res http.ResponseWriter, req *http.Request, response Response) {
+	switch {
+	case handler.fault != NoFault:
+		handler.injectFault(res, req, response)
+	case handler.events != nil:
+		if !handler.streamEvents(res, req, response) {
+			return
+		}
+	default:
+		if !handler.writeResponse(res, req, response) {
+			return
+		}
+	}
+	handler.transition()
+	if handler.events != nil && handler.streamEnd == DropConnection {
+		// Aborting the handler closes the connection (or resets the HTTP/2 stream) without ending the response
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// writeHead writes the response headers and status
+func (handler *TestHandler) writeHead(res http.ResponseWriter, response Response) {
+	for _, headers := range []http.Header{handler.responseHeaders, response.Headers} {
+		for key, value := range headers {
 			for _, subValue := range value {
 				res.Header().Add(key, subValue)
 			}
 		}
 	}
-	// Write response status code
 	status := http.StatusOK
-	if handler.responseStatus != 0 {
-		status = handler.responseStatus
+	if response.Status != 0 {
+		status = response.Status
 	}
 	res.WriteHeader(status)
+}
+
+// writeResponse writes the response headers, status and body
+// It returns false if the request's context is cancelled before the body is written
+func (handler *TestHandler) writeResponse(res http.ResponseWriter, req *http.Request, response Response) bool {
+	handler.writeHead(res, response)
+	if handler.stall > 0 {
+		flush(res)
+		if !sleep(req.Context(), handler.stall) {
+			return false
+		}
+	}
 	// Write response body
-	if handler.responseBody != nil {
-		if _, err := res.Write(handler.responseBody); err != nil {
+	if response.Body != nil {
+		if err := handler.writeBody(res, req, response.Body); err != nil {
+			if req.Context().Err() != nil {
+				return false
+			}
 			handler.errorHandler.HandleError(
 				res,
 				req,
 				http.StatusInternalServerError,
 				errors.Wrap(err, "Failed to write response body"),
 			)
-			return
 		}
 	}
+	return true
+}
+
+// writeBody writes the response body in a single write, or in chunks if the TestHandler streams it
+func (handler *TestHandler) writeBody(res http.ResponseWriter, req *http.Request, body []byte) error {
+	if handler.streamed() {
+		return handler.writeChunks(res, req, body)
+	}
+	_, err := res.Write(body)
+	return err
+}
+
+// checkRequest checks the request against all the TestHandler's requirements
+// and returns a MismatchError listing every mismatch, or nil if the request matches
+func (handler *TestHandler) checkRequest(req *http.Request) *MismatchError {
+	reqBody, err := readBody(req)
+	if err != nil {
+		return newMismatchError(http.StatusInternalServerError, errors.Wrap(err, "Cannot read request body"))
+	}
+	mismatch := newMismatchError(
+		http.StatusBadRequest,
+		handler.checkProto(req),
+		handler.checkPathParams(req),
+		checkQuery(handler.queryParams, req.URL.Query()),
+		handler.checkHeaders(req),
+		handler.checkBody(reqBody),
+		checkMatchers(handler.matchers, req),
+	)
+	if len(mismatch.Reasons) == 0 {
+		return nil
+	}
+	return mismatch
+}
+
+// checkPathParams returns an error if the request lacks any of the required path parameters
+func (handler *TestHandler) checkPathParams(req *http.Request) error {
+	if actual := PathParams(req); !containsAllParams(handler.pathParams, actual) {
+		return errors.Errorf(
+			"Required path params does not match with the actual path params.\nRequired:\n%+v\nActual:\n%+v\n",
+			handler.pathParams,
+			actual)
+	}
+	return nil
+}
+
+// checkHeaders returns an error if the request lacks any of the required headers
+func (handler *TestHandler) checkHeaders(req *http.Request) error {
+	if !containsAll(handler.requestHeaders, req.Header) {
+		return errors.Errorf(
+			"Required headers does not match with the actual headers.\nRequired:\n%+v\nActual:\n%+v\n",
+			handler.requestHeaders,
+			req.Header)
+	}
+	return nil
+}
+
+// checkBody returns an error if the request body differs from the required one or the required JSON
+func (handler *TestHandler) checkBody(reqBody []byte) error {
+	if handler.requestBody != nil && !bytes.Equal(reqBody, handler.requestBody) {
+		return errors.Errorf(
+			"Required request body does not match with the actual request body.\nRequired:\n%s\nActual:\n%s\n",
+			handler.requestBody,
+			reqBody)
+	}
+	if handler.jsonBody != nil {
+		return handler.jsonBody.check(reqBody)
+	}
+	return nil
+}
+
+// WithPathParam adds a required path parameter to the TestHandler and returns it
+func (handler *TestHandler) WithPathParam(name string, value string) *TestHandler {
+	handler.pathParams[name] = value
+	return handler
+}
+
+// AddPathParam adds a required path parameter to the TestHandler
+func (handler *TestHandler) AddPathParam(name string, value string) {
+	handler.pathParams[name] = value
 }
 
 // WithRequestHeader adds a required request header to the TestHandler and returns it
@@ -198,6 +384,20 @@ func (handler *TestHandler) AddResponseHeaders(headers map[string][]string) {
 	}
 }
 
+// readBody is a helper method which reads the request's body and replaces it,
+// so it can be read again
+func readBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil {
+		return nil, nil
+	}
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
 // containsAll is a helper method which can tell if the actual request headers
 // contains all the required request headers
 func containsAll(required, actual map[string][]string) bool {
@@ -217,6 +417,17 @@ func containsAll(required, actual map[string][]string) bool {
 	return true
 }
 
+// containsAllParams is a helper method which can tell if the actual path parameters
+// contains all the required path parameters with the same value
+func containsAllParams(required, actual map[string]string) bool {
+	for name, value := range required {
+		if actualValue, ok := actual[name]; !ok || actualValue != value {
+			return false
+		}
+	}
+	return true
+}
+
 // stringInSlice is a helper method to check if a string is in a slice of strings
 func stringInSlice(required string, list []string) bool {
 	for _, elem := range list {
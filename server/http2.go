@@ -0,0 +1,67 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+// NewHTTP2Server is like NewTLSServer, but the Server negotiates HTTP/2 with the clients supporting it
+// Its Client uses HTTP/2
+func NewHTTP2Server(handler http.Handler) *Server {
+	return newTLSServer(handler, tlsOptions{http2: true})
+}
+
+// NewH2CServer creates a new Server with the supplied handler, serving HTTP/2 without TLS (h2c)
+// besides HTTP/1.1. Its Client uses h2c with prior knowledge
+// It is immediately initialized and can be reached at Server.URL
+func NewH2CServer(handler http.Handler) *Server {
+	httpServer, cancel := newUnstartedServer(handler)
+	httpServer.Config.Protocols = new(http.Protocols)
+	httpServer.Config.Protocols.SetHTTP1(true)
+	httpServer.Config.Protocols.SetUnencryptedHTTP2(true)
+	httpServer.Start()
+	server := &Server{Server: httpServer, cancel: cancel, protocols: new(http.Protocols)}
+	server.protocols.SetUnencryptedHTTP2(true)
+	server.client = server.NewClient()
+	return server
+}
+
+// InitHTTP2 inits the TestServer's underlying Server as an HTTPS server supporting HTTP/2 (see NewHTTP2Server)
+func (ts *TestServer) InitHTTP2() {
+	ts.Server = NewHTTP2Server(ts.router)
+}
+
+// InitH2C inits the TestServer's underlying Server as a cleartext HTTP/2 server (see NewH2CServer)
+func (ts *TestServer) InitH2C() {
+	ts.Server = NewH2CServer(ts.router)
+}
+
+// WithRequestProto adds a required protocol version (like HTTP/2.0) to the TestHandler and returns it
+// An invalid protocol version is reported by the TestHandler (see Err)
+func (handler *TestHandler) WithRequestProto(proto string) *TestHandler {
+	handler.AddRequestProto(proto)
+	return handler
+}
+
+// AddRequestProto adds a required protocol version (like HTTP/2.0) to the TestHandler
+// An invalid protocol version is reported by the TestHandler (see Err)
+func (handler *TestHandler) AddRequestProto(proto string) {
+	if _, _, ok := http.ParseHTTPVersion(proto); !ok {
+		handler.invalidOption(errors.Errorf("Invalid protocol version %q", proto))
+		return
+	}
+	handler.requestProto = proto
+}
+
+// checkProto returns an error if the request's protocol version differs from the required one
+func (handler *TestHandler) checkProto(req *http.Request) error {
+	if handler.requestProto == "" {
+		return nil
+	}
+	major, minor, _ := http.ParseHTTPVersion(handler.requestProto)
+	if req.ProtoMajor != major || req.ProtoMinor != minor {
+		return errors.Errorf("proto: expected %s got %s", handler.requestProto, req.Proto)
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewHTTP2Server(t *testing.T) {
+	t.Log("Testing HTTP/2 over TLS...")
+
+	srv := NewTestServer(t)
+	srv.Handle("^/h2$", "GET", srv.Handler().WithRequestProto("HTTP/2.0"))
+	srv.InitHTTP2()
+	defer srv.Close()
+
+	resp, err := srv.Client().Get(srv.URL + "/h2")
+	require.NoError(t, err, "Test server shouldn't return any errors")
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode, "Response should be HTTP 200 OK")
+	require.Equal(t, "HTTP/2.0", resp.Proto, "HTTP/2 should be negotiated")
+	require.Equal(t, "HTTP/2.0", srv.LastRequest().Proto, "The journal should record the protocol")
+}
+
+func TestNewH2CServer(t *testing.T) {
+	t.Log("Testing cleartext HTTP/2...")
+
+	srv := NewTestServer(t)
+	srv.Handle("^/h2c$", "GET", srv.Handler().WithRequestProto("HTTP/2.0"))
+	srv.Handle("^/any$", "GET", srv.Handler())
+	srv.InitH2C()
+	defer srv.Close()
+
+	resp, err := srv.Client().Get(srv.URL + "/h2c")
+	require.NoError(t, err, "Test server shouldn't return any errors")
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode, "Response should be HTTP 200 OK")
+	require.Equal(t, "HTTP/2.0", resp.Proto, "HTTP/2 should be used without TLS")
+	require.Equal(t, "HTTP/2.0", srv.LastRequest().Proto, "The journal should record the protocol")
+
+	resp, err = http.Get(srv.URL + "/any")
+	require.NoError(t, err, "HTTP/1.1 clients should still be served")
+	resp.Body.Close()
+	require.Equal(t, "HTTP/1.1", srv.LastRequest().Proto, "The journal should record the protocol")
+}
+
+func TestTestHandler_WithRequestProto(t *testing.T) {
+	t.Log("Testing required protocol versions...")
+
+	srv := NewServer(NewRouter(nil).WithRoute(MustNewRoute("^/h2$", nil).
+		WithMethod("GET", NewTestHandler(nil).WithRequestProto("HTTP/2.0"))))
+	defer srv.Close()
+	status, body := conditionalRequest(t, srv.URL+"/h2", nil)
+	require.Equal(t, http.StatusBadRequest, status, "Other protocol versions should not match")
+	require.Contains(t, body, "proto: expected HTTP/2.0 got HTTP/1.1", "The mismatch should be reported")
+
+	require.EqualError(t, NewTestHandler(nil).WithRequestProto("h2").Err(),
+		"Invalid TestHandler options:\nInvalid protocol version \"h2\"", "Invalid protocol versions should be reported")
+	require.Error(t, NewRouter(nil).Handle("^/h2$", "GET", NewTestHandler(nil).WithRequestProto("HTTP/x")),
+		"Handlers with invalid protocol versions should not be registered")
+
+	_, err := ParseDefinition([]byte("routes: [{regex: '^/a$', request: {proto: HTTP/2.0}}]"))
+	require.NoError(t, err, "The definition should be parsed")
+	invalid, err := ParseDefinition([]byte("routes: [{regex: '^/a$', request: {proto: h2}}]"))
+	require.NoError(t, err, "The definition should be parsed")
+	_, err = invalid.Router(nil)
+	require.Error(t, err, "Invalid protocol versions should be rejected in definitions")
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// RecordedRequest is a snapshot of a request that passed through a Router
+// MatchedRoute is the regex of the Route the request was passed to, or empty if no Route matched
+// Proto is the negotiated protocol of the request, like HTTP/1.1 or HTTP/2.0
+type RecordedRequest struct {
+	Method       string
+	Proto        string
+	URL          *url.URL
+	Header       http.Header
+	Body         []byte
+	Timestamp    time.Time
+	MatchedRoute string
+}
+
+// MarshalJSON encodes the RecordedRequest with its URL and body as strings
+func (request RecordedRequest) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Method       string      `json:"method"`
+		Proto        string      `json:"proto"`
+		URL          string      `json:"url"`
+		Header       http.Header `json:"header"`
+		Body         string      `json:"body"`
+		Timestamp    time.Time   `json:"timestamp"`
+		MatchedRoute string      `json:"matchedRoute"`
+	}{
+		Method:       request.Method,
+		Proto:        request.Proto,
+		URL:          request.URL.String(),
+		Header:       request.Header,
+		Body:         string(request.Body),
+		Timestamp:    request.Timestamp,
+		MatchedRoute: request.MatchedRoute,
+	})
+}
+
+// Journal is a thread-safe list of RecordedRequests
+type Journal struct {
+	requests []RecordedRequest
+	mutex    sync.RWMutex
+}
+
+// NewJournal creates a new empty Journal and returns its pointer
+func NewJournal() *Journal {
+	return &Journal{}
+}
+
+// Record appends a RecordedRequest to the Journal
+func (journal *Journal) Record(request RecordedRequest) {
+	journal.mutex.Lock()
+	defer journal.mutex.Unlock()
+	journal.requests = append(journal.requests, request)
+}
+
+// Requests returns a copy of all the RecordedRequests in the order they arrived
+func (journal *Journal) Requests() []RecordedRequest {
+	journal.mutex.RLock()
+	defer journal.mutex.RUnlock()
+	requests := make([]RecordedRequest, len(journal.requests))
+	copy(requests, journal.requests)
+	return requests
+}
+
+// RequestsFor returns the RecordedRequests with the given method whose URL path matches the given regex
+// An empty method matches any method
+func (journal *Journal) RequestsFor(pathRegex *regexp.Regexp, method string) []RecordedRequest {
+	var requests []RecordedRequest
+	for _, request := range journal.Requests() {
+		if (method == "" || request.Method == method) && pathRegex.MatchString(request.URL.Path) {
+			requests = append(requests, request)
+		}
+	}
+	return requests
+}
+
+// LastRequest returns the last RecordedRequest, or nil if the Journal is empty
+func (journal *Journal) LastRequest() *RecordedRequest {
+	journal.mutex.RLock()
+	defer journal.mutex.RUnlock()
+	if len(journal.requests) == 0 {
+		return nil
+	}
+	request := journal.requests[len(journal.requests)-1]
+	return &request
+}
+
+// Reset removes all the RecordedRequests from the Journal
+func (journal *Journal) Reset() {
+	journal.mutex.Lock()
+	defer journal.mutex.Unlock()
+	journal.requests = nil
+}
+
+// recordRequest creates a RecordedRequest from the given request
+// The request's body is read and replaced, so it can be read again by the handlers
+func recordRequest(req *http.Request, matchedRoute string) (RecordedRequest, error) {
+	body, err := readBody(req)
+	if err != nil {
+		return RecordedRequest{}, err
+	}
+	reqURL := *req.URL
+	return RecordedRequest{
+		Method:       req.Method,
+		Proto:        req.Proto,
+		URL:          &reqURL,
+		Header:       req.Header.Clone(),
+		Body:         body,
+		Timestamp:    time.Now(),
+		MatchedRoute: matchedRoute,
+	}, nil
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestJournal(t *testing.T) {
+	t.Log("Testing request journal...")
+
+	srv := NewTestServer(t)
+	srv.Handle("/test/journal$", "POST", srv.Handler().WithRequestBody([]byte("Journal body")))
+	srv.Handle("/test/journal$", "GET", srv.Handler())
+	srv.Init()
+	defer srv.Close()
+
+	require.Nil(t, srv.LastRequest(), "The journal should be empty before any requests")
+
+	resp, err := http.Post(srv.URL+"/test/journal", "text/plain", bytes.NewReader([]byte("Journal body")))
+	require.NoError(t, err, "Test server shouldn't return any errors")
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode, "The handler should still be able to read the body")
+
+	getResp, getErr := http.Get(srv.URL + "/test/journal?page=2")
+	require.NoError(t, getErr, "Test server shouldn't return any errors")
+	defer getResp.Body.Close()
+
+	require.Len(t, srv.Requests(), 2, "The journal should contain two requests")
+
+	posts := srv.RequestsFor("/test/journal$", "POST")
+	require.Len(t, posts, 1, "The journal should contain one POST request")
+	require.Equal(t, []byte("Journal body"), posts[0].Body, "The request body should be recorded")
+	require.Equal(t, "text/plain", posts[0].Header.Get("Content-Type"), "The request headers should be recorded")
+	require.Equal(t, "/test/journal$", posts[0].MatchedRoute, "The matched route should be recorded")
+	require.False(t, posts[0].Timestamp.IsZero(), "The timestamp should be recorded")
+
+	last := srv.LastRequest()
+	require.NotNil(t, last, "The journal should have a last request")
+	require.Equal(t, "GET", last.Method, "The last request should be the GET request")
+	require.Equal(t, "2", last.URL.Query().Get("page"), "The URL should be recorded with its query")
+	require.Len(t, srv.RequestsFor("/test/journal", ""), 2, "An empty method should match any method")
+}
+
+func TestJournal_unmatched(t *testing.T) {
+	t.Log("Testing request journal on unmatched routes...")
+
+	journal := NewJournal()
+	srv := NewServer(NewRouter(nil).WithJournal(journal))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/non/existent/path")
+	require.NoError(t, err, "GET shouldn't return any errors")
+	defer resp.Body.Close()
+	_, readErr := ioutil.ReadAll(resp.Body)
+	require.NoError(t, readErr, "The response body should be readable")
+
+	require.Len(t, journal.Requests(), 1, "Unmatched requests should be recorded too")
+	require.Empty(t, journal.LastRequest().MatchedRoute, "Unmatched requests should have no matched route")
+
+	journal.Reset()
+	require.Empty(t, journal.Requests(), "The journal should be empty after Reset")
+}
@@ -0,0 +1,189 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// jsonRequirement is a required JSON request body
+// In subset mode only the fields listed in the expected document must match,
+// any extra field in the actual document is accepted
+type jsonRequirement struct {
+	expected interface{}
+	subset   bool
+}
+
+// newJSONRequirement parses the expected JSON document, it panics if the document is invalid
+func newJSONRequirement(body []byte, subset bool) *jsonRequirement {
+	expected, err := decodeJSON(body)
+	if err != nil {
+		panic(errors.Wrapf(err, "Invalid required JSON request body %q", body))
+	}
+	return &jsonRequirement{expected: expected, subset: subset}
+}
+
+// decodeJSON decodes a JSON document with its numbers as json.Number,
+// so large integers are compared without losing their precision (see jsonDiff)
+func decodeJSON(data []byte) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, errors.New("invalid data after the top-level JSON value")
+	}
+	return value, nil
+}
+
+// check returns an error listing every field level difference between the expected and the actual body
+func (requirement *jsonRequirement) check(body []byte) error {
+	actual, err := decodeJSON(body)
+	if err != nil {
+		return errors.Wrapf(err, "Required JSON request body, got invalid JSON:\n%s\n", body)
+	}
+	diff := jsonDiff("$", requirement.expected, actual, requirement.subset)
+	if len(diff) > 0 {
+		return errors.Errorf(
+			"Required JSON request body does not match with the actual request body.\n%s\n",
+			strings.Join(diff, "\n"))
+	}
+	return nil
+}
+
+// jsonDiff compares two decoded JSON values and returns a line for every difference
+// in the form of `$.path.to.field: expected "a" got "b"`
+// Numbers are compared by their exact value, so 1 equals 1.0 but 9007199254740993 differs from 9007199254740992
+func jsonDiff(path string, expected, actual interface{}, subset bool) []string {
+	switch expectedValue := expected.(type) {
+	case map[string]interface{}:
+		actualValue, ok := actual.(map[string]interface{})
+		if !ok {
+			return []string{mismatch(path, expected, actual)}
+		}
+		return jsonObjectDiff(path, expectedValue, actualValue, subset)
+	case []interface{}:
+		actualValue, ok := actual.([]interface{})
+		if !ok {
+			return []string{mismatch(path, expected, actual)}
+		}
+		return jsonArrayDiff(path, expectedValue, actualValue, subset)
+	case json.Number:
+		actualValue, ok := actual.(json.Number)
+		if !ok || !equalNumbers(expectedValue, actualValue) {
+			return []string{mismatch(path, expected, actual)}
+		}
+		return nil
+	default:
+		if expected != actual {
+			return []string{mismatch(path, expected, actual)}
+		}
+		return nil
+	}
+}
+
+// equalNumbers tells if two JSON numbers have the same exact value
+func equalNumbers(a, b json.Number) bool {
+	if a == b {
+		return true
+	}
+	aValue, aOk := new(big.Rat).SetString(a.String())
+	bValue, bOk := new(big.Rat).SetString(b.String())
+	return aOk && bOk && aValue.Cmp(bValue) == 0
+}
+
+// jsonObjectDiff compares two JSON objects field by field in key order
+func jsonObjectDiff(path string, expected, actual map[string]interface{}, subset bool) []string {
+	var diff []string
+	for _, key := range sortedKeys(expected) {
+		actualField, ok := actual[key]
+		if !ok {
+			diff = append(diff, fmt.Sprintf("%s.%s: expected %s got nothing", path, key, encodeJSON(expected[key])))
+			continue
+		}
+		diff = append(diff, jsonDiff(path+"."+key, expected[key], actualField, subset)...)
+	}
+	if subset {
+		return diff
+	}
+	for _, key := range sortedKeys(actual) {
+		if _, ok := expected[key]; !ok {
+			diff = append(diff, fmt.Sprintf("%s.%s: expected nothing got %s", path, key, encodeJSON(actual[key])))
+		}
+	}
+	return diff
+}
+
+// jsonArrayDiff compares two JSON arrays element by element, their length must be the same
+func jsonArrayDiff(path string, expected, actual []interface{}, subset bool) []string {
+	if len(expected) != len(actual) {
+		return []string{fmt.Sprintf("%s: expected %d elements got %d", path, len(expected), len(actual))}
+	}
+	var diff []string
+	for i := range expected {
+		diff = append(diff, jsonDiff(fmt.Sprintf("%s[%d]", path, i), expected[i], actual[i], subset)...)
+	}
+	return diff
+}
+
+// mismatch formats a single JSON value difference
+func mismatch(path string, expected, actual interface{}) string {
+	return fmt.Sprintf("%s: expected %s got %s", path, encodeJSON(expected), encodeJSON(actual))
+}
+
+// encodeJSON encodes a decoded JSON value back to its JSON text
+func encodeJSON(value interface{}) string {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	return string(encoded)
+}
+
+// sortedKeys returns the keys of a JSON object in alphabetical order
+func sortedKeys(object map[string]interface{}) []string {
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// WithJSONRequestBody adds a required JSON request body to the TestHandler and returns it
+// The actual body must be semantically equal to it, key order and whitespace does not matter
+// It panics if the given body is not valid JSON
+func (handler *TestHandler) WithJSONRequestBody(body []byte) *TestHandler {
+	handler.AddJSONRequestBody(body)
+	return handler
+}
+
+// AddJSONRequestBody adds a required JSON request body to the TestHandler
+// The actual body must be semantically equal to it, key order and whitespace does not matter
+// It panics if the given body is not valid JSON
+func (handler *TestHandler) AddJSONRequestBody(body []byte) {
+	handler.jsonBody = newJSONRequirement(body, false)
+}
+
+// WithJSONRequestBodySubset adds a required JSON request body to the TestHandler and returns it
+// Only the fields listed in it must match, the actual body may contain any other field
+// It panics if the given body is not valid JSON
+func (handler *TestHandler) WithJSONRequestBodySubset(body []byte) *TestHandler {
+	handler.AddJSONRequestBodySubset(body)
+	return handler
+}
+
+// AddJSONRequestBodySubset adds a required JSON request body to the TestHandler
+// Only the fields listed in it must match, the actual body may contain any other field
+// It panics if the given body is not valid JSON
+func (handler *TestHandler) AddJSONRequestBodySubset(body []byte) {
+	handler.jsonBody = newJSONRequirement(body, true)
+}
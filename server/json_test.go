@@ -0,0 +1,78 @@
+package server
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestJSONDiff(t *testing.T) {
+	t.Log("Testing JSON diff...")
+
+	requirement := newJSONRequirement([]byte(`{"user": {"name": "a", "tags": [1, 2]}, "id": 1}`), false)
+	require.NoError(t,
+		requirement.check([]byte(`{"id":1,"user":{"tags":[1,2],"name":"a"}}`)),
+		"Key order and whitespace shouldn't matter")
+
+	err := requirement.check([]byte(`{"user": {"name": "b", "tags": [1, 3], "age": 3}}`))
+	require.Error(t, err, "Different documents should not match")
+	for _, expected := range []string{
+		`$.id: expected 1 got nothing`,
+		`$.user.name: expected "a" got "b"`,
+		`$.user.tags[1]: expected 2 got 3`,
+		`$.user.age: expected nothing got 3`,
+	} {
+		require.Contains(t, err.Error(), expected, "The error should contain the field level diff")
+	}
+
+	subset := newJSONRequirement([]byte(`{"user": {"name": "a"}}`), true)
+	require.NoError(t,
+		subset.check([]byte(`{"user": {"name": "a", "age": 3}, "id": 1}`)),
+		"Extra fields should be accepted in subset mode")
+	require.Error(t, subset.check([]byte(`{"user": "a"}`)), "Type mismatch should fail in subset mode")
+	require.Error(t, subset.check([]byte(`not json`)), "Invalid JSON should fail")
+
+	require.Panics(t, func() { NewTestHandler(nil).WithJSONRequestBody([]byte("{")) }, "Invalid JSON should panic")
+}
+
+func TestJSONDiff_numbers(t *testing.T) {
+	t.Log("Testing JSON number comparison...")
+
+	requirement := newJSONRequirement([]byte(`{"id": 9007199254740993, "price": 1.5, "count": 1}`), false)
+	require.NoError(t,
+		requirement.check([]byte(`{"id": 9007199254740993, "price": 1.50, "count": 1.0}`)),
+		"Numbers with the same value should match")
+	require.EqualError(t,
+		requirement.check([]byte(`{"id": 9007199254740992, "price": 15e-1, "count": 1e0}`)),
+		"Required JSON request body does not match with the actual request body.\n"+
+			"$.id: expected 9007199254740993 got 9007199254740992\n",
+		"Integers above 2^53 should keep their precision")
+	require.Error(t, requirement.check([]byte(`{"id": 1} {}`)), "Data after the document should be invalid")
+}
+
+func TestJSONRequestBody(t *testing.T) {
+	t.Log("Testing JSON request body...")
+
+	handler := NewTestHandler(nil).WithJSONRequestBodySubset([]byte(`{"user": {"name": "a"}}`))
+	srv := NewServer(handler)
+	defer srv.Close()
+
+	resp, err := http.Post(srv.URL, "application/json", bytes.NewReader([]byte(`{"id": 1, "user": {"name": "a"}}`)))
+	require.NoError(t, err, "Test server shouldn't return any errors")
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode, "Response should be HTTP 200 OK")
+
+	badResp, badErr := http.Post(srv.URL, "application/json", bytes.NewReader([]byte(`{"user": {"name": "b"}}`)))
+	require.NoError(t, badErr, "Test server shouldn't return any errors")
+	defer badResp.Body.Close()
+	require.Equal(t, http.StatusBadRequest, badResp.StatusCode, "Response should be HTTP 400 Bad Request")
+	respBody, readErr := ioutil.ReadAll(badResp.Body)
+	require.NoError(t, readErr, "The response body should be readable")
+	require.True(t,
+		strings.Contains(string(respBody), `$.user.name: expected "a" got "b"`),
+		"The response should contain the field level diff")
+}
@@ -0,0 +1,360 @@
+package server
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// defaultMaxMemory is the memory limit used when parsing multipart forms
+const defaultMaxMemory = 32 << 20
+
+// RequestMatcher is a requirement on the incoming request
+// Match returns an error describing the mismatch, or nil if the request matches
+// Matchers must not consume the request's body, use readBody to access it
+type RequestMatcher interface {
+	Match(req *http.Request) error
+}
+
+// RequestMatcherFunc is an adapter to use an ordinary function as a RequestMatcher
+type RequestMatcherFunc func(req *http.Request) error
+
+// Match calls the function itself
+func (matcher RequestMatcherFunc) Match(req *http.Request) error {
+	return matcher(req)
+}
+
+// WithMatcher adds a RequestMatcher to the TestHandler and returns it
+func (handler *TestHandler) WithMatcher(matcher RequestMatcher) *TestHandler {
+	handler.AddMatcher(matcher)
+	return handler
+}
+
+// AddMatcher adds a RequestMatcher to the TestHandler
+func (handler *TestHandler) AddMatcher(matcher RequestMatcher) {
+	handler.matchers = append(handler.matchers, matcher)
+}
+
+// MatchHeaderRegex requires any value of the given header to match the regex
+// It returns an error if the regex is invalid
+func MatchHeaderRegex(key string, regex string) (RequestMatcher, error) {
+	compiled, err := regexp.Compile(regex)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Invalid regex of header %q", key)
+	}
+	return RequestMatcherFunc(func(req *http.Request) error {
+		values := req.Header[http.CanonicalHeaderKey(key)]
+		if !anyMatches(compiled, values) {
+			return errors.Errorf("header %q: expected to match %q got %q", key, regex, values)
+		}
+		return nil
+	}), nil
+}
+
+// MustMatchHeaderRegex is like MatchHeaderRegex but panics if the regex is invalid
+func MustMatchHeaderRegex(key string, regex string) RequestMatcher {
+	matcher, err := MatchHeaderRegex(key, regex)
+	if err != nil {
+		panic(err)
+	}
+	return matcher
+}
+
+// MatchBodyRegex requires the request body to match the regex
+// It returns an error if the regex is invalid
+func MatchBodyRegex(regex string) (RequestMatcher, error) {
+	compiled, err := regexp.Compile(regex)
+	if err != nil {
+		return nil, errors.Wrap(err, "Invalid body regex")
+	}
+	return RequestMatcherFunc(func(req *http.Request) error {
+		body, err := readBody(req)
+		if err != nil {
+			return errors.Wrap(err, "Cannot read request body")
+		}
+		if !compiled.Match(body) {
+			return errors.Errorf("body: expected to match %q got %q", regex, body)
+		}
+		return nil
+	}), nil
+}
+
+// MustMatchBodyRegex is like MatchBodyRegex but panics if the regex is invalid
+func MustMatchBodyRegex(regex string) RequestMatcher {
+	matcher, err := MatchBodyRegex(regex)
+	if err != nil {
+		panic(err)
+	}
+	return matcher
+}
+
+// MatchBodyContains requires the request body to contain the given bytes
+func MatchBodyContains(part []byte) RequestMatcher {
+	return RequestMatcherFunc(func(req *http.Request) error {
+		body, err := readBody(req)
+		if err != nil {
+			return errors.Wrap(err, "Cannot read request body")
+		}
+		if !bytes.Contains(body, part) {
+			return errors.Errorf("body: expected to contain %q got %q", part, body)
+		}
+		return nil
+	})
+}
+
+// MatchJSONPath requires the value at the given path of the JSON request body to equal the given value
+// The path is a simple JSONPath like $.user.name or $.items[0].id
+// The value is compared as JSON, so 1 equals 1.0 and maps equal objects
+func MatchJSONPath(path string, value interface{}) RequestMatcher {
+	return RequestMatcherFunc(func(req *http.Request) error {
+		body, err := readBody(req)
+		if err != nil {
+			return errors.Wrap(err, "Cannot read request body")
+		}
+		document, err := decodeJSON(body)
+		if err != nil {
+			return errors.Wrapf(err, "%s: expected JSON body got %q", path, body)
+		}
+		actual, err := evalJSONPath(path, document)
+		if err != nil {
+			return err
+		}
+		expected, err := normalizeJSON(value)
+		if err != nil {
+			return err
+		}
+		if diff := jsonDiff(path, expected, actual, false); len(diff) > 0 {
+			return errors.New(strings.Join(diff, "\n"))
+		}
+		return nil
+	})
+}
+
+// MatchFormField requires the URL encoded or multipart form field to have the given value
+// The temporary files of a multipart form are removed after matching
+func MatchFormField(key string, value string) RequestMatcher {
+	return RequestMatcherFunc(func(req *http.Request) error {
+		form, err := parsedForm(req)
+		if err != nil {
+			return err
+		}
+		if form.MultipartForm != nil {
+			defer form.MultipartForm.RemoveAll()
+		}
+		values := form.Form[key]
+		if !stringInSlice(value, values) {
+			return errors.Errorf("form field %q: expected %q got %q", key, value, values)
+		}
+		return nil
+	})
+}
+
+// MatchMultipartPart requires the multipart form to have a file part with the given name and content
+func MatchMultipartPart(name string, content []byte) RequestMatcher {
+	return RequestMatcherFunc(func(req *http.Request) error {
+		form, err := parsedForm(req)
+		if err != nil {
+			return err
+		}
+		if form.MultipartForm == nil {
+			return errors.Errorf("multipart part %q: expected multipart form", name)
+		}
+		defer form.MultipartForm.RemoveAll()
+		if len(form.MultipartForm.File[name]) == 0 {
+			return errors.Errorf("multipart part %q: expected to be present", name)
+		}
+		for _, header := range form.MultipartForm.File[name] {
+			file, err := header.Open()
+			if err != nil {
+				return errors.Wrapf(err, "Cannot open multipart part %q", name)
+			}
+			partContent, err := ioutil.ReadAll(file)
+			file.Close()
+			if err != nil {
+				return errors.Wrapf(err, "Cannot read multipart part %q", name)
+			}
+			if bytes.Equal(partContent, content) {
+				return nil
+			}
+		}
+		return errors.Errorf("multipart part %q: expected content %q", name, content)
+	})
+}
+
+// MatchCookie requires the request to have a cookie with the given name and value
+func MatchCookie(name string, value string) RequestMatcher {
+	return RequestMatcherFunc(func(req *http.Request) error {
+		cookie, err := req.Cookie(name)
+		if err != nil {
+			return errors.Errorf("cookie %q: expected %q got nothing", name, value)
+		}
+		if cookie.Value != value {
+			return errors.Errorf("cookie %q: expected %q got %q", name, value, cookie.Value)
+		}
+		return nil
+	})
+}
+
+// MatchBasicAuth requires the request to use HTTP Basic Authentication with the given credentials
+func MatchBasicAuth(username string, password string) RequestMatcher {
+	return RequestMatcherFunc(func(req *http.Request) error {
+		actualUsername, actualPassword, ok := req.BasicAuth()
+		if !ok {
+			return errors.New("basic auth: expected credentials got nothing")
+		}
+		if actualUsername != username || actualPassword != password {
+			return errors.Errorf("basic auth: expected user %q got user %q with different credentials",
+				username, actualUsername)
+		}
+		return nil
+	})
+}
+
+// MatchClientCertCommonName requires the request to present a verified TLS client certificate
+// whose subject has the given common name
+func MatchClientCertCommonName(commonName string) RequestMatcher {
+	return RequestMatcherFunc(func(req *http.Request) error {
+		certificate := clientCertificate(req)
+		if certificate == nil {
+			return errors.Errorf("client certificate: expected common name %q got nothing", commonName)
+		}
+		if certificate.Subject.CommonName != commonName {
+			return errors.Errorf("client certificate: expected common name %q got %q",
+				commonName, certificate.Subject.CommonName)
+		}
+		return nil
+	})
+}
+
+// MatchClientCertSubject requires the request to present a verified TLS client certificate
+// whose subject (like CN=client,O=Acme) matches the regex
+// It returns an error if the regex is invalid
+func MatchClientCertSubject(regex string) (RequestMatcher, error) {
+	compiled, err := regexp.Compile(regex)
+	if err != nil {
+		return nil, errors.Wrap(err, "Invalid client certificate subject regex")
+	}
+	return RequestMatcherFunc(func(req *http.Request) error {
+		certificate := clientCertificate(req)
+		if certificate == nil {
+			return errors.Errorf("client certificate: expected subject to match %q got nothing", regex)
+		}
+		if subject := certificate.Subject.String(); !compiled.MatchString(subject) {
+			return errors.Errorf("client certificate: expected subject to match %q got %q", regex, subject)
+		}
+		return nil
+	}), nil
+}
+
+// MustMatchClientCertSubject is like MatchClientCertSubject but panics if the regex is invalid
+func MustMatchClientCertSubject(regex string) RequestMatcher {
+	matcher, err := MatchClientCertSubject(regex)
+	if err != nil {
+		panic(err)
+	}
+	return matcher
+}
+
+// clientCertificate returns the verified leaf client certificate of the request, or nil if it has none
+func clientCertificate(req *http.Request) *x509.Certificate {
+	if req.TLS == nil || len(req.TLS.VerifiedChains) == 0 || len(req.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return req.TLS.VerifiedChains[0][0]
+}
+
+// checkMatchers runs all the matchers and returns a single error listing every mismatch
+func checkMatchers(matchers []RequestMatcher, req *http.Request) error {
+	var mismatches []string
+	for _, matcher := range matchers {
+		if err := matcher.Match(req); err != nil {
+			mismatches = append(mismatches, err.Error())
+		}
+	}
+	if len(mismatches) > 0 {
+		return errors.Errorf("Request does not match the required matchers.\n%s\n", strings.Join(mismatches, "\n"))
+	}
+	return nil
+}
+
+// parsedForm returns a copy of the request with its form parsed, leaving the original body readable
+func parsedForm(req *http.Request) (*http.Request, error) {
+	body, err := readBody(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "Cannot read request body")
+	}
+	form := req.WithContext(req.Context())
+	form.Body = ioutil.NopCloser(bytes.NewReader(body))
+	form.Form, form.PostForm, form.MultipartForm = nil, nil, nil
+	if strings.HasPrefix(req.Header.Get("Content-Type"), "multipart/form-data") {
+		err = form.ParseMultipartForm(defaultMaxMemory)
+	} else {
+		err = form.ParseForm()
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "Cannot parse request form")
+	}
+	return form, nil
+}
+
+// jsonPathSegment matches a single .key or [index] segment of a JSONPath
+var jsonPathSegment = regexp.MustCompile(`^(?:\.([^.\[]+)|\[(\d+)\])`)
+
+// evalJSONPath returns the value at the given path of the decoded JSON document
+func evalJSONPath(path string, document interface{}) (interface{}, error) {
+	if !strings.HasPrefix(path, "$") {
+		return nil, errors.Errorf("%s: JSONPath must start with $", path)
+	}
+	current, rest := document, path[1:]
+	for rest != "" {
+		segment := jsonPathSegment.FindStringSubmatch(rest)
+		if segment == nil {
+			return nil, errors.Errorf("%s: invalid JSONPath at %q", path, rest)
+		}
+		rest = rest[len(segment[0]):]
+		var ok bool
+		if current, ok = jsonPathStep(current, segment[1], segment[2]); !ok {
+			return nil, errors.Errorf("%s: expected to be present got nothing at %q", path, segment[0])
+		}
+	}
+	return current, nil
+}
+
+// jsonPathStep returns the field with the given key of a JSON object,
+// or if key is empty the element with the given index of a JSON array
+func jsonPathStep(current interface{}, key string, index string) (interface{}, bool) {
+	if key != "" {
+		object, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		value, ok := object[key]
+		return value, ok
+	}
+	array, ok := current.([]interface{})
+	position, err := strconv.Atoi(index)
+	if !ok || err != nil || position >= len(array) {
+		return nil, false
+	}
+	return array[position], true
+}
+
+// normalizeJSON converts any Go value to its decoded JSON form, so it can be compared with jsonDiff
+func normalizeJSON(value interface{}) (interface{}, error) {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return nil, errors.Wrap(err, "Cannot encode expected JSON value")
+	}
+	normalized, err := decodeJSON(encoded)
+	if err != nil {
+		return nil, errors.Wrap(err, "Cannot decode expected JSON value")
+	}
+	return normalized, nil
+}
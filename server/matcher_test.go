@@ -0,0 +1,182 @@
+package server
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatchers(t *testing.T) {
+	t.Log("Testing built-in request matchers...")
+
+	srv := NewTestServer(t)
+	srv.Handle("^/test/json$", "POST", srv.Handler().
+		WithMatcher(MustMatchHeaderRegex("Authorization", `^Bearer \w+$`)).
+		WithMatcher(MustMatchBodyRegex(`"id":\s*\d+`)).
+		WithMatcher(MatchBodyContains([]byte("alice"))).
+		WithMatcher(MatchJSONPath("$.user.name", "alice")).
+		WithMatcher(MatchJSONPath("$.items[1].id", 2)).
+		WithRequestBody([]byte(`{"id": 1, "user": {"name": "alice"}, "items": [{"id": 1}, {"id": 2}]}`)))
+	srv.Handle("^/test/form$", "POST", srv.Handler().
+		WithMatcher(MatchFormField("name", "alice")).
+		WithMatcher(MatchCookie("session", "abc")).
+		WithMatcher(MatchBasicAuth("user", "pass")))
+	srv.Handle("^/test/multipart$", "POST", srv.Handler().
+		WithMatcher(MatchFormField("name", "alice")).
+		WithMatcher(MatchMultipartPart("file", []byte("file content"))))
+	srv.Init()
+	defer srv.Close()
+
+	jsonReq, err := http.NewRequest("POST", srv.URL+"/test/json",
+		strings.NewReader(`{"id": 1, "user": {"name": "alice"}, "items": [{"id": 1}, {"id": 2}]}`))
+	require.NoError(t, err, "Test request should be created")
+	jsonReq.Header.Set("Authorization", "Bearer token")
+	jsonResp, err := http.DefaultClient.Do(jsonReq)
+	require.NoError(t, err, "Test server shouldn't return any errors")
+	defer jsonResp.Body.Close()
+	require.Equal(t, http.StatusOK, jsonResp.StatusCode, "The JSON request should match")
+
+	formReq, err := http.NewRequest("POST", srv.URL+"/test/form", strings.NewReader(url.Values{"name": {"alice"}}.Encode()))
+	require.NoError(t, err, "Test request should be created")
+	formReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	formReq.AddCookie(&http.Cookie{Name: "session", Value: "abc"})
+	formReq.SetBasicAuth("user", "pass")
+	formResp, err := http.DefaultClient.Do(formReq)
+	require.NoError(t, err, "Test server shouldn't return any errors")
+	defer formResp.Body.Close()
+	require.Equal(t, http.StatusOK, formResp.StatusCode, "The form request should match")
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	require.NoError(t, writer.WriteField("name", "alice"), "The form field should be written")
+	part, err := writer.CreateFormFile("file", "file.txt")
+	require.NoError(t, err, "The form file should be created")
+	_, err = part.Write([]byte("file content"))
+	require.NoError(t, err, "The form file should be written")
+	require.NoError(t, writer.Close(), "The multipart writer should be closed")
+	multipartResp, err := http.Post(srv.URL+"/test/multipart", writer.FormDataContentType(), &body)
+	require.NoError(t, err, "Test server shouldn't return any errors")
+	defer multipartResp.Body.Close()
+	require.Equal(t, http.StatusOK, multipartResp.StatusCode, "The multipart request should match")
+}
+
+func TestMatchers_mismatch(t *testing.T) {
+	t.Log("Testing request matcher mismatches...")
+
+	handler := NewTestHandler(nil).
+		WithMatcher(MustMatchHeaderRegex("Authorization", `^Bearer \w+$`)).
+		WithMatcher(MatchJSONPath("$.user.name", "alice")).
+		WithMatcher(MatchCookie("session", "abc")).
+		WithMatcher(MatchBasicAuth("user", "pass")).
+		WithMatcher(RequestMatcherFunc(func(req *http.Request) error { return nil }))
+	srv := NewServer(handler)
+	defer srv.Close()
+
+	resp, err := http.Post(srv.URL, "application/json", strings.NewReader(`{"user": {"name": "bob"}}`))
+	require.NoError(t, err, "Test server shouldn't return any errors")
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode, "Response should be HTTP 400 Bad Request")
+
+	respBody, readErr := ioutil.ReadAll(resp.Body)
+	require.NoError(t, readErr, "The response body should be readable")
+	for _, expected := range []string{
+		`header "Authorization": expected to match "^Bearer \\w+$" got []`,
+		`$.user.name: expected "alice" got "bob"`,
+		`cookie "session": expected "abc" got nothing`,
+		`basic auth: expected credentials got nothing`,
+	} {
+		require.Truef(t,
+			strings.Contains(string(respBody), expected),
+			"Expected response should contain:\n%s\nActual Response:\n%s\n", expected, respBody)
+	}
+}
+
+func TestEvalJSONPath(t *testing.T) {
+	t.Log("Testing JSONPath evaluation...")
+
+	document := map[string]interface{}{
+		"items": []interface{}{map[string]interface{}{"id": 1.0}},
+	}
+	value, err := evalJSONPath("$.items[0].id", document)
+	require.NoError(t, err, "The path should be found")
+	require.Equal(t, 1.0, value, "The value should be returned")
+
+	_, err = evalJSONPath("$.items[1]", document)
+	require.Error(t, err, "Out of range indexes should fail")
+	_, err = evalJSONPath("items", document)
+	require.Error(t, err, "Paths must start with $")
+	_, err = evalJSONPath("$..items", document)
+	require.Error(t, err, "Invalid paths should fail")
+}
+
+func TestMatchClientCert(t *testing.T) {
+	t.Log("Testing client certificate matchers...")
+
+	request, err := http.NewRequest("GET", "/", nil)
+	require.NoError(t, err, "Test request should be created")
+	require.EqualError(t, MatchClientCertCommonName("client").Match(request),
+		`client certificate: expected common name "client" got nothing`, "Plain requests should not match")
+	require.EqualError(t, MustMatchClientCertSubject("^CN=client$").Match(request),
+		`client certificate: expected subject to match "^CN=client$" got nothing`, "Plain requests should not match")
+
+	request.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{
+		{Subject: pkix.Name{CommonName: "other", Organization: []string{"Acme"}}},
+	}}}
+	require.EqualError(t, MatchClientCertCommonName("client").Match(request),
+		`client certificate: expected common name "client" got "other"`, "Other common names should not match")
+	require.NoError(t, MustMatchClientCertSubject("CN=other").Match(request), "The subject should match")
+	require.EqualError(t, MustMatchClientCertSubject("^CN=client$").Match(request),
+		`client certificate: expected subject to match "^CN=client$" got "CN=other,O=Acme"`,
+		"Other subjects should not match")
+}
+
+func TestMatchers_invalid_regex(t *testing.T) {
+	t.Log("Testing regex matchers with invalid regexes...")
+
+	_, err := MatchHeaderRegex("Authorization", "(")
+	require.EqualError(t, err, "Invalid regex of header \"Authorization\": error parsing regexp: missing closing ): `(`",
+		"Invalid header regexes should be reported")
+	_, err = MatchBodyRegex("(")
+	require.EqualError(t, err, "Invalid body regex: error parsing regexp: missing closing ): `(`",
+		"Invalid body regexes should be reported")
+	_, err = MatchClientCertSubject("(")
+	require.EqualError(t, err, "Invalid client certificate subject regex: error parsing regexp: missing closing ): `(`",
+		"Invalid subject regexes should be reported")
+	require.Panics(t, func() { MustMatchBodyRegex("(") }, "Must variants should panic on invalid regexes")
+}
+
+func TestMatchFormField_removes_files(t *testing.T) {
+	t.Log("Testing that the form field matcher removes the temporary files of multipart forms...")
+
+	dir, err := ioutil.TempDir("", "mokk-form")
+	require.NoError(t, err, "Temp dir should be created")
+	defer os.RemoveAll(dir)
+	t.Setenv("TMPDIR", dir)
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	require.NoError(t, writer.WriteField("name", "mokk"), "Form field should be written")
+	part, err := writer.CreateFormFile("upload", "large.bin")
+	require.NoError(t, err, "Form file should be created")
+	_, err = part.Write(make([]byte, defaultMaxMemory+1))
+	require.NoError(t, err, "Form file should be written")
+	require.NoError(t, writer.Close(), "Multipart writer should be closed")
+	request, err := http.NewRequest("POST", "/", &body)
+	require.NoError(t, err, "Test request should be created")
+	request.Header.Set("Content-Type", writer.FormDataContentType())
+
+	require.NoError(t, MatchFormField("name", "mokk").Match(request), "The form field should match")
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err, "Temp dir should be readable")
+	require.Empty(t, files, "Temporary files of the multipart form should be removed")
+}
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// maxNearMisses is the number of closest matches listed in the diagnostics of unmatched requests
+const maxNearMisses = 3
+
+// nearMiss is a handler which did not handle the request, with the reasons why
+// handler is its position among the handlers of its method, counted from 1
+// similarity is the length of the common prefix of the request's target and the regex's literal prefix
+// status is the HTTP status its mismatch is reported with
+type nearMiss struct {
+	method     string
+	regex      string
+	handler    int
+	handlers   int
+	reasons    []string
+	similarity int
+	status     int
+}
+
+// nearMisses returns the handlers of the Routes closest to the request, see rankNearMisses
+// The target is what the Routes' regexes are matched against (see Router.WithFullURLMatching)
+func nearMisses(routes []*Route, req *http.Request, target string) []nearMiss {
+	var misses []nearMiss
+	for _, route := range routes {
+		misses = append(misses, route.nearMisses(req, target)...)
+	}
+	return rankNearMisses(misses)
+}
+
+// rankNearMisses returns the closest near misses, ranked by the similarity of their paths,
+// then by their number of mismatches, the ones added earlier first among equal ones
+func rankNearMisses(misses []nearMiss) []nearMiss {
+	sort.SliceStable(misses, func(i, j int) bool {
+		if misses[i].similarity != misses[j].similarity {
+			return misses[i].similarity > misses[j].similarity
+		}
+		return len(misses[i].reasons) < len(misses[j].reasons)
+	})
+	if len(misses) > maxNearMisses {
+		misses = misses[:maxNearMisses]
+	}
+	return misses
+}
+
+// nearMisses returns every handler of the Route with the reasons it did not handle the request
+// Without a target the path is not checked, as the Route has already matched it
+// The requirements of the handlers are only checked if the path matches,
+// as checking them can have side effects (see RequestMatcherFunc)
+func (route *Route) nearMisses(req *http.Request, target string) []nearMiss {
+	var pathReasons []string
+	similarity := 0
+	pathMatches := target == "" || route.regex.MatchString(target)
+	if !pathMatches {
+		pathReasons = []string{fmt.Sprintf("path: expected to match %q got %q", route.Regex(), target)}
+		similarity = commonPrefixLength(target, literalPrefix(route.Regex()))
+	}
+	var misses []nearMiss
+	handlers := route.Handlers()
+	for _, method := range route.Methods() {
+		methodReasons := pathReasons
+		if method != req.Method {
+			methodReasons = append(methodReasons[:len(methodReasons):len(methodReasons)],
+				fmt.Sprintf("method: expected %s got %s", method, req.Method))
+		}
+		for i, handler := range handlers[method] {
+			reasons := methodReasons
+			if conditional, ok := handler.(Conditional); ok && pathMatches {
+				reasons = append(reasons[:len(reasons):len(reasons)], mismatchReasons(conditional.Applies(req))...)
+			}
+			misses = append(misses, nearMiss{
+				method:     method,
+				regex:      route.Regex(),
+				handler:    i + 1,
+				handlers:   len(handlers[method]),
+				reasons:    reasons,
+				similarity: similarity,
+			})
+		}
+	}
+	return misses
+}
+
+// literalPrefix returns the literal text the regex starts with, after its ^ anchor
+func literalPrefix(regex string) string {
+	regex = strings.TrimPrefix(regex, "^")
+	if end := strings.IndexAny(regex, `\.+*?()|[]{}^$`); end >= 0 {
+		return regex[:end]
+	}
+	return regex
+}
+
+// commonPrefixLength returns the length of the common prefix of the strings
+func commonPrefixLength(a, b string) int {
+	length := 0
+	for length < len(a) && length < len(b) && a[length] == b[length] {
+		length++
+	}
+	return length
+}
+
+// mismatchStatus returns the status of a MismatchError, or HTTP 404 Not Found for other errors
+func mismatchStatus(err error) int {
+	if mismatch, ok := err.(*MismatchError); ok {
+		return mismatch.Status
+	}
+	return http.StatusNotFound
+}
+
+// mismatchReasons returns the reasons of a MismatchError, or the error itself as the only reason
+func mismatchReasons(err error) []string {
+	if err == nil {
+		return nil
+	}
+	if mismatch, ok := err.(*MismatchError); ok {
+		return mismatch.Reasons
+	}
+	return []string{err.Error()}
+}
+
+// formatNearMisses returns the ranked list of the near misses, with their reasons indented below them,
+// or an empty string if there is none
+func formatNearMisses(misses []nearMiss) string {
+	if len(misses) == 0 {
+		return ""
+	}
+	var report strings.Builder
+	report.WriteString("\nClosest matches:")
+	for i, miss := range misses {
+		fmt.Fprintf(&report, "\n%d. %s %s", i+1, miss.method, miss.regex)
+		if miss.handlers > 1 {
+			fmt.Fprintf(&report, " (handler %d of %d)", miss.handler, miss.handlers)
+		}
+		for _, reason := range miss.reasons {
+			report.WriteString("\n\t" + strings.Replace(strings.TrimRight(reason, "\n"), "\n", "\n\t", -1))
+		}
+	}
+	return report.String()
+}
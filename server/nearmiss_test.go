@@ -0,0 +1,69 @@
+package server
+
+import (
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRouter_near_misses(t *testing.T) {
+	t.Log("Testing closest match diagnostics of unmatched requests...")
+
+	matcherCalls := 0
+	router := NewRouter(nil).
+		WithRoute(MustNewRoute("^/orders$", nil).WithMethod("GET", NewTestHandler(nil))).
+		WithRoute(MustNewRoute("^/users$", nil).
+			WithMethod("GET", NewTestHandler(nil).
+				WithRequestHeader("X-Token", "secret").
+				WithMatcher(RequestMatcherFunc(func(req *http.Request) error {
+					matcherCalls++
+					return nil
+				}))).
+			WithMethod("POST", NewTestHandler(nil))).
+		WithRoute(MustNewRoute("^/a$", nil).WithMethod("GET", NewTestHandler(nil))).
+		WithRoute(MustNewRoute("^/b$", nil).WithMethod("GET", NewTestHandler(nil)))
+	srv := NewServer(router)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/userz")
+	require.NoError(t, err, "Test server shouldn't return any errors")
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err, "Response body should be read")
+	require.Equal(t, http.StatusNotFound, resp.StatusCode, "Unmatched requests should return HTTP 404")
+	require.Contains(t, string(body), "Not found: /userz\nClosest matches:\n"+
+		"1. GET ^/users$\n"+
+		"\tpath: expected to match \"^/users$\" got \"/userz\"\n2.",
+		"The most similar route should be ranked first")
+	require.NotContains(t, string(body), "Required headers", "The requirements of unmatched paths should not be checked")
+	require.Equal(t, 0, matcherCalls, "The matchers of unmatched paths should not be called")
+	require.Contains(t, string(body), "2. POST ^/users$\n"+
+		"\tpath: expected to match \"^/users$\" got \"/userz\"\n"+
+		"\tmethod: expected POST got GET\n3. GET ^/orders$",
+		"The other routes should be ranked by similarity then by mismatches")
+	require.NotContains(t, string(body), "4.", "Only the closest matches should be listed")
+}
+
+func TestRoute_near_misses(t *testing.T) {
+	t.Log("Testing closest match diagnostics of unsupported methods...")
+
+	route := MustNewRoute("^/items$", nil).
+		WithMethod("PUT", NewTestHandler(nil).WithRequestHeader("X-Token", "secret")).
+		WithMethod("POST", NewTestHandler(nil))
+	srv := NewServer(NewRouter(nil).WithRoute(route))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/items")
+	require.NoError(t, err, "Test server shouldn't return any errors")
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err, "Response body should be read")
+	require.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode, "Unsupported methods should return HTTP 405")
+	require.Contains(t, string(body), "Method not allowed\nClosest matches:\n"+
+		"1. POST ^/items$\n\tmethod: expected POST got GET\n"+
+		"2. PUT ^/items$\n\tmethod: expected PUT got GET\n\tRequired headers",
+		"The Route's handlers should be ranked by their mismatches")
+	require.NotContains(t, string(body), "path:", "The matched path should not be reported")
+}
@@ -0,0 +1,40 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+)
+
+// pathParamsKey is the context key of the path parameters extracted by the Router
+type pathParamsKey struct{}
+
+// PathParams returns all the named path parameters the Router extracted from the request's URL
+// using the matching Route's regex capture groups, like (?P<id>\d+)
+// It returns nil if the request did not pass through a Router
+func PathParams(req *http.Request) map[string]string {
+	params, _ := req.Context().Value(pathParamsKey{}).(map[string]string)
+	return params
+}
+
+// PathParam returns the named path parameter with the given name,
+// or an empty string if there is no such parameter
+func PathParam(req *http.Request, name string) string {
+	return PathParams(req)[name]
+}
+
+// withPathParams returns a shallow copy of the request with the given path parameters in its context
+func withPathParams(req *http.Request, params map[string]string) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), pathParamsKey{}, params))
+}
+
+// namedGroups returns the named capture groups of a regex submatch
+func namedGroups(regex *regexp.Regexp, match []string) map[string]string {
+	params := make(map[string]string)
+	for i, name := range regex.SubexpNames() {
+		if name != "" && i < len(match) {
+			params[name] = match[i]
+		}
+	}
+	return params
+}
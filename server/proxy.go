@@ -0,0 +1,71 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+
+	"github.com/pkg/errors"
+)
+
+// Proxy is a Handler which passes the requests through to an upstream server
+// It is meant to be the fallback Handler of a Router (see Router.WithFallback), so only a few endpoints
+// of a real service are stubbed and everything else reaches the service
+type Proxy struct {
+	reverseProxy *httputil.ReverseProxy
+	errorHandler ErrorHandler
+}
+
+// NewProxy creates a Proxy to the given upstream URL and returns its pointer
+// The Host header of the proxied requests is set to the upstream's host, the responses are streamed
+// If nil ErrorHandler is provided it will fall back to the BasicErrorHandler
+func NewProxy(upstream string, errHandler ErrorHandler) (*Proxy, error) {
+	target, err := url.Parse(upstream)
+	if err != nil || target.Scheme == "" || target.Host == "" {
+		return nil, errors.Errorf("Invalid upstream URL %q", upstream)
+	}
+	var handler ErrorHandler = &BasicErrorHandler{}
+	if errHandler != nil {
+		handler = errHandler
+	}
+	proxy := &Proxy{
+		reverseProxy: httputil.NewSingleHostReverseProxy(target),
+		errorHandler: handler,
+	}
+	director := proxy.reverseProxy.Director
+	proxy.reverseProxy.Director = func(req *http.Request) {
+		director(req)
+		req.Host = target.Host
+	}
+	proxy.reverseProxy.FlushInterval = -1
+	proxy.reverseProxy.ErrorHandler = func(res http.ResponseWriter, req *http.Request, err error) {
+		proxy.errorHandler.HandleError(res, req, http.StatusBadGateway, errors.Wrap(err, "Upstream request failed"))
+	}
+	return proxy, nil
+}
+
+// ServeHTTP passes the request through to the upstream and copies its response
+// If the upstream cannot be reached the ErrorHandler is called with 502 Bad Gateway
+func (proxy *Proxy) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	proxy.reverseProxy.ServeHTTP(res, req)
+}
+
+// WithProxy sets a Proxy to the given upstream URL as the Router's fallback and returns the Router
+// It panics if the upstream URL is invalid
+func (router *Router) WithProxy(upstream string) *Router {
+	if err := router.AddProxy(upstream); err != nil {
+		panic(err)
+	}
+	return router
+}
+
+// AddProxy sets a Proxy to the given upstream URL as the Router's fallback
+// The Proxy uses the Router's ErrorHandler
+func (router *Router) AddProxy(upstream string) error {
+	proxy, err := NewProxy(upstream, router.errorHandler)
+	if err != nil {
+		return err
+	}
+	router.AddFallback(proxy)
+	return nil
+}
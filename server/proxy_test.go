@@ -0,0 +1,63 @@
+package server
+
+import (
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestProxy(t *testing.T) {
+	t.Log("Testing passing unmatched requests through to an upstream...")
+
+	var upstreamHost string
+	upstream := NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		upstreamHost = req.Host
+		res.Header().Set("X-Upstream", "true")
+		res.Write([]byte("Upstream " + req.URL.Path))
+	}))
+	defer upstream.Close()
+
+	srv := NewTestServer(t)
+	srv.Handle("^/stubbed$", "GET", srv.Handler().WithResponseBody([]byte("Stubbed")))
+	srv.ProxyTo(upstream.URL)
+	srv.Init()
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/stubbed")
+	require.NoError(t, err, "Test server shouldn't return any errors")
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err, "Response body should be read")
+	require.Equal(t, "Stubbed", string(body), "Matched requests should be stubbed")
+
+	resp, err = http.Get(srv.URL + "/real?q=1")
+	require.NoError(t, err, "Test server shouldn't return any errors")
+	body, err = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err, "Response body should be read")
+	require.Equal(t, "Upstream /real", string(body), "Unmatched requests should pass through")
+	require.Equal(t, "true", resp.Header.Get("X-Upstream"), "Upstream headers should pass through")
+	require.Equal(t, upstream.Listener.Addr().String(), upstreamHost, "The Host should be the upstream's")
+	require.Len(t, srv.Requests(), 2, "Proxied requests should be recorded in the journal")
+}
+
+func TestProxy_errors(t *testing.T) {
+	t.Log("Testing proxy errors...")
+
+	_, err := NewProxy("not a url", nil)
+	require.Error(t, err, "Invalid upstream URLs should be rejected")
+	require.Panics(t, func() { NewRouter(nil).WithProxy("/relative") }, "WithProxy should panic on invalid URLs")
+
+	upstream := NewServer(http.NotFoundHandler())
+	upstreamURL := upstream.URL
+	upstream.Close()
+
+	srv := NewServer(NewRouter(nil).WithProxy(upstreamURL))
+	defer srv.Close()
+	resp, err := http.Get(srv.URL + "/anything")
+	require.NoError(t, err, "Test server shouldn't return any errors")
+	resp.Body.Close()
+	require.Equal(t, http.StatusBadGateway, resp.StatusCode, "Unreachable upstreams should return HTTP 502")
+}
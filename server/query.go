@@ -0,0 +1,136 @@
+package server
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// queryRequirement is a requirement on a single query parameter of the request
+// Exactly one of its checks is used: value, regex, present or absent
+type queryRequirement struct {
+	key     string
+	value   string
+	regex   *regexp.Regexp
+	present bool
+	absent  bool
+}
+
+// check returns an error if the given query values do not satisfy the requirement
+func (requirement queryRequirement) check(query url.Values) error {
+	values, ok := query[requirement.key]
+	switch {
+	case requirement.absent:
+		if ok {
+			return errors.Errorf("query param %q: expected to be absent got %q", requirement.key, values)
+		}
+	case !ok:
+		return errors.Errorf("query param %q: expected to be present", requirement.key)
+	case requirement.regex != nil:
+		if !anyMatches(requirement.regex, values) {
+			return errors.Errorf("query param %q: expected to match %q got %q", requirement.key, requirement.regex, values)
+		}
+	case !requirement.present:
+		if !stringInSlice(requirement.value, values) {
+			return errors.Errorf("query param %q: expected %q got %q", requirement.key, requirement.value, values)
+		}
+	}
+	return nil
+}
+
+// checkQuery checks all the query requirements against the given query
+// and returns a single error listing every mismatching parameter
+func checkQuery(requirements []queryRequirement, query url.Values) error {
+	var mismatches []string
+	for _, requirement := range requirements {
+		if err := requirement.check(query); err != nil {
+			mismatches = append(mismatches, err.Error())
+		}
+	}
+	if len(mismatches) > 0 {
+		return errors.Errorf(
+			"Required query params does not match with the actual query params.\n%s\nActual:\n%+v\n",
+			strings.Join(mismatches, "\n"),
+			query)
+	}
+	return nil
+}
+
+// anyMatches tells if any of the values matches the regex
+func anyMatches(regex *regexp.Regexp, values []string) bool {
+	for _, value := range values {
+		if regex.MatchString(value) {
+			return true
+		}
+	}
+	return false
+}
+
+// WithQueryParam adds a required query parameter with an exact value to the TestHandler and returns it
+func (handler *TestHandler) WithQueryParam(key string, value string) *TestHandler {
+	handler.AddQueryParam(key, value)
+	return handler
+}
+
+// AddQueryParam adds a required query parameter with an exact value to the TestHandler
+func (handler *TestHandler) AddQueryParam(key string, value string) {
+	handler.queryParams = append(handler.queryParams, queryRequirement{key: key, value: value})
+}
+
+// WithQueryParams adds all required query parameters with exact values to the TestHandler and returns it
+func (handler *TestHandler) WithQueryParams(params map[string][]string) *TestHandler {
+	handler.AddQueryParams(params)
+	return handler
+}
+
+// AddQueryParams adds all required query parameters with exact values to the TestHandler
+func (handler *TestHandler) AddQueryParams(params map[string][]string) {
+	for key, values := range params {
+		for _, value := range values {
+			handler.AddQueryParam(key, value)
+		}
+	}
+}
+
+// WithQueryParamRegex adds a query parameter to the TestHandler which is required to match the given regex
+// and returns it. An invalid regex is reported by the TestHandler (see Err)
+func (handler *TestHandler) WithQueryParamRegex(key string, regex string) *TestHandler {
+	handler.AddQueryParamRegex(key, regex)
+	return handler
+}
+
+// AddQueryParamRegex adds a query parameter to the TestHandler which is required to match the given regex
+// An invalid regex is reported by the TestHandler (see Err)
+func (handler *TestHandler) AddQueryParamRegex(key string, regex string) {
+	compiled, err := regexp.Compile(regex)
+	if err != nil {
+		handler.invalidOption(errors.Wrapf(err, "Invalid regex of query param %q", key))
+		return
+	}
+	handler.queryParams = append(handler.queryParams, queryRequirement{key: key, regex: compiled})
+}
+
+// WithQueryParamPresent adds a query parameter to the TestHandler which is required to be present
+// with any value and returns it
+func (handler *TestHandler) WithQueryParamPresent(key string) *TestHandler {
+	handler.AddQueryParamPresent(key)
+	return handler
+}
+
+// AddQueryParamPresent adds a query parameter to the TestHandler which is required to be present with any value
+func (handler *TestHandler) AddQueryParamPresent(key string) {
+	handler.queryParams = append(handler.queryParams, queryRequirement{key: key, present: true})
+}
+
+// WithQueryParamAbsent adds a query parameter to the TestHandler which is required to be absent and returns it
+func (handler *TestHandler) WithQueryParamAbsent(key string) *TestHandler {
+	handler.AddQueryParamAbsent(key)
+	return handler
+}
+
+// AddQueryParamAbsent adds a query parameter to the TestHandler which is required to be absent
+func (handler *TestHandler) AddQueryParamAbsent(key string) {
+	handler.queryParams = append(handler.queryParams, queryRequirement{key: key, absent: true})
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueryParams(t *testing.T) {
+	t.Log("Testing query parameter requirements...")
+
+	srv := NewTestServer(t)
+	srv.Handle("^/test/query$", "GET", srv.Handler().
+		WithQueryParam("page", "2").
+		WithQueryParams(map[string][]string{"tag": {"a", "b"}}).
+		WithQueryParamRegex("id", `^\d+$`).
+		WithQueryParamPresent("debug").
+		WithQueryParamAbsent("secret"))
+	srv.Init()
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/test/query?page=2&tag=a&tag=b&id=123&debug")
+	require.NoError(t, err, "Test server shouldn't return any errors")
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode, "Response should be HTTP 200 OK")
+}
+
+func TestQueryParams_mismatch(t *testing.T) {
+	t.Log("Testing query parameter mismatch...")
+
+	handler := NewTestHandler(nil).
+		WithQueryParam("page", "2").
+		WithQueryParamRegex("id", `^\d+$`).
+		WithQueryParamPresent("debug").
+		WithQueryParamAbsent("secret")
+	srv := NewServer(handler)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/?page=3&id=abc&secret=1")
+	require.NoError(t, err, "Test server shouldn't return any errors")
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode, "Response should be HTTP 400 Bad Request")
+
+	respBody, readErr := ioutil.ReadAll(resp.Body)
+	require.NoError(t, readErr, "The response body should be readable")
+	for _, expected := range []string{
+		`query param "page": expected "2" got ["3"]`,
+		`query param "id": expected to match "^\\d+$" got ["abc"]`,
+		`query param "debug": expected to be present`,
+		`query param "secret": expected to be absent got ["1"]`,
+	} {
+		require.Truef(t,
+			strings.Contains(string(respBody), expected),
+			"Expected response should contain:\n%s\nActual Response:\n%s\n", expected, respBody)
+	}
+}
+
+func TestRouter_query_string(t *testing.T) {
+	t.Log("Testing routes with query strings...")
+
+	srv := NewTestServer(t)
+	srv.Handle("^/test/path$", "GET", srv.Handler())
+	srv.Init()
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/test/path?page=2")
+	require.NoError(t, err, "Test server shouldn't return any errors")
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode, "Anchored routes should match regardless of the query")
+
+	fullURLRouter := NewRouter(nil).
+		WithFullURLMatching(true).
+		WithRoute(MustNewRoute(`^/test/path\?page=2$`, nil).WithMethod("GET", NewTestHandler(nil)))
+	fullURLSrv := NewServer(fullURLRouter)
+	defer fullURLSrv.Close()
+
+	fullResp, fullErr := http.Get(fullURLSrv.URL + "/test/path?page=2")
+	require.NoError(t, fullErr, "Test server shouldn't return any errors")
+	defer fullResp.Body.Close()
+	require.Equal(t, http.StatusOK, fullResp.StatusCode, "Full URL matching should match the query too")
+}
+
+func TestQueryParams_invalid_regex(t *testing.T) {
+	t.Log("Testing invalid query parameter regexes...")
+
+	handler := NewTestHandler(nil).WithQueryParamRegex("id", `^(\d+$`)
+	require.EqualError(t, handler.Err(),
+		"Invalid TestHandler options:\nInvalid regex of query param \"id\": error parsing regexp: missing closing ): `^(\\d+$`",
+		"The invalid regex should be reported")
+	require.Error(t, NewRouter(nil).Handle("^/test$", "GET", handler), "The handler should not be registered")
+
+	srv := NewServer(handler)
+	defer srv.Close()
+	resp, err := http.Get(srv.URL + "/?id=1")
+	require.NoError(t, err, "Test server shouldn't return any errors")
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusInternalServerError, resp.StatusCode, "An invalid handler should not respond")
+}
@@ -0,0 +1,259 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"go/format"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// serverPackage is the import path of this package, used by the Go code of the recordings
+const serverPackage = "github.com/mikloslorinczi/mokk/server"
+
+// skippedResponseHeaders are not recorded, because they are set by the server on replay
+var skippedResponseHeaders = map[string]bool{
+	"Connection":        true,
+	"Content-Length":    true,
+	"Date":              true,
+	"Keep-Alive":        true,
+	"Transfer-Encoding": true,
+}
+
+// Recording is a request and the response the upstream returned for it
+type Recording struct {
+	Request  RecordedRequest
+	Response Response
+}
+
+// Recorder is a Handler which proxies the requests to an upstream server and records them with the responses
+// It is meant to be the fallback Handler of a Router (see Router.WithFallback), so only the unmatched requests
+// reach the upstream. The recordings can be written out as a mock Definition or Go code, to be replayed later
+type Recorder struct {
+	proxy        *Proxy
+	recordings   []Recording
+	mutex        sync.Mutex
+	errorHandler ErrorHandler
+}
+
+// NewRecorder creates a Recorder proxying to the given upstream URL and returns its pointer
+// If nil ErrorHandler is provided it will fall back to the BasicErrorHandler
+func NewRecorder(upstream string, errHandler ErrorHandler) (*Recorder, error) {
+	proxy, err := NewProxy(upstream, errHandler)
+	if err != nil {
+		return nil, err
+	}
+	recorder := &Recorder{
+		proxy:        proxy,
+		errorHandler: proxy.errorHandler,
+	}
+	proxy.reverseProxy.ModifyResponse = recorder.record
+	return recorder, nil
+}
+
+// ServeHTTP proxies the request to the upstream and records the request with the upstream's response
+func (recorder *Recorder) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	request, err := recordRequest(req, "")
+	if err != nil {
+		recorder.errorHandler.HandleError(
+			res,
+			req,
+			http.StatusInternalServerError,
+			errors.Wrap(err, "Cannot read request body"))
+		return
+	}
+	recorder.proxy.ServeHTTP(res, req.WithContext(withRecordedRequest(req.Context(), request)))
+}
+
+// record saves the upstream's response with its request, and replaces the response body so it can still be proxied
+func (recorder *Recorder) record(resp *http.Response) error {
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return errors.Wrap(err, "Cannot read upstream response body")
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	headers := make(http.Header)
+	for key, values := range resp.Header {
+		if !skippedResponseHeaders[key] {
+			headers[key] = append([]string(nil), values...)
+		}
+	}
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+	recorder.recordings = append(recorder.recordings, Recording{
+		Request:  recordedRequestFrom(resp.Request.Context()),
+		Response: Response{Status: resp.StatusCode, Headers: headers, Body: body},
+	})
+	return nil
+}
+
+// Recordings returns a copy of all the Recordings in the order the responses arrived
+func (recorder *Recorder) Recordings() []Recording {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+	recordings := make([]Recording, len(recorder.recordings))
+	copy(recordings, recorder.recordings)
+	return recordings
+}
+
+// Definition converts the Recordings into a mock Definition
+// Every recorded path, query and method becomes a route matching exactly that path and requiring the query,
+// the first recording of each wins. Bodies which are not valid UTF-8 (like gzip) are written as base64
+func (recorder *Recorder) Definition() *Definition {
+	definition := &Definition{}
+	for _, recording := range uniqueRecordings(recorder.Recordings()) {
+		var query map[string][]string
+		if recorded := recording.Request.URL.Query(); len(recorded) > 0 {
+			query = recorded
+		}
+		response := ResponseDefinition{
+			Status:  recording.Response.Status,
+			Headers: Values(recording.Response.Headers),
+		}
+		if body := recording.Response.Body; utf8.Valid(body) {
+			text := string(body)
+			response.Body = &text
+		} else {
+			response.BodyBase64 = base64.StdEncoding.EncodeToString(body)
+		}
+		definition.Routes = append(definition.Routes, RouteDefinition{
+			Regex:    pathRegex(recording.Request.URL.Path),
+			Method:   recording.Request.Method,
+			Request:  RequestDefinition{Query: query},
+			Response: response,
+		})
+	}
+	return definition
+}
+
+// WriteDefinitionFile writes the Recordings into a mock definition file, see WriteDefinitionFile
+func (recorder *Recorder) WriteDefinitionFile(path string) error {
+	return WriteDefinitionFile(path, recorder.Definition())
+}
+
+// WriteGo writes the Recordings as a formatted Go source file of the given package,
+// with a registerRecorded function registering TestHandlers on a TestServer, like
+//
+//	func registerRecorded(srv *server.TestServer) {
+//		srv.Handle("^/users$", "GET", srv.Handler().
+//			WithResponseStatus(200))
+//	}
+func (recorder *Recorder) WriteGo(w io.Writer, packageName string) error {
+	var code bytes.Buffer
+	fmt.Fprintf(&code, "package %s\n\nimport %s\n\n", packageName, strconv.Quote(serverPackage))
+	code.WriteString("// registerRecorded registers the recorded responses on the TestServer\n")
+	code.WriteString("func registerRecorded(srv *server.TestServer) {\n")
+	for _, recording := range uniqueRecordings(recorder.Recordings()) {
+		fmt.Fprintf(&code, "srv.Handle(%s, %s, srv.Handler().\nWithResponseStatus(%d)",
+			strconv.Quote(pathRegex(recording.Request.URL.Path)),
+			strconv.Quote(recording.Request.Method),
+			recording.Response.Status)
+		query := recording.Request.URL.Query()
+		for _, key := range sortedHeaderKeys(http.Header(query)) {
+			for _, value := range query[key] {
+				fmt.Fprintf(&code, ".\nWithQueryParam(%s, %s)", strconv.Quote(key), strconv.Quote(value))
+			}
+		}
+		for _, key := range sortedHeaderKeys(recording.Response.Headers) {
+			for _, value := range recording.Response.Headers[key] {
+				fmt.Fprintf(&code, ".\nWithResponseHeader(%s, %s)", strconv.Quote(key), strconv.Quote(value))
+			}
+		}
+		if len(recording.Response.Body) > 0 {
+			fmt.Fprintf(&code, ".\nWithResponseBody([]byte(%s))", quoteBytes(recording.Response.Body))
+		}
+		code.WriteString(")\n")
+	}
+	code.WriteString("}\n")
+	formatted, err := format.Source(code.Bytes())
+	if err != nil {
+		return errors.Wrap(err, "Cannot format Go code")
+	}
+	_, err = w.Write(formatted)
+	return errors.Wrap(err, "Cannot write Go code")
+}
+
+// recordedRequestKey is the context key of the RecordedRequest of a proxied request
+type recordedRequestKey struct{}
+
+// withRecordedRequest returns a copy of the context with the RecordedRequest in it
+func withRecordedRequest(ctx context.Context, request RecordedRequest) context.Context {
+	return context.WithValue(ctx, recordedRequestKey{}, request)
+}
+
+// recordedRequestFrom returns the RecordedRequest of the context
+func recordedRequestFrom(ctx context.Context) RecordedRequest {
+	request, _ := ctx.Value(recordedRequestKey{}).(RecordedRequest)
+	return request
+}
+
+// uniqueRecordings returns the first Recording of every method, path and query
+// They are ordered by the number of their query values, so the handlers with more specific queries
+// are added later and tried first by their Route
+func uniqueRecordings(recordings []Recording) []Recording {
+	seen := make(map[string]bool)
+	var unique []Recording
+	for _, recording := range recordings {
+		key := recording.Request.Method + " " + recording.Request.URL.RequestURI()
+		if !seen[key] {
+			seen[key] = true
+			unique = append(unique, recording)
+		}
+	}
+	sort.SliceStable(unique, func(i, j int) bool {
+		return queryValues(unique[i].Request.URL.Query()) < queryValues(unique[j].Request.URL.Query())
+	})
+	return unique
+}
+
+// queryValues returns the number of values of the query
+func queryValues(query url.Values) int {
+	count := 0
+	for _, values := range query {
+		count += len(values)
+	}
+	return count
+}
+
+// pathRegex returns a regex matching exactly the given path
+func pathRegex(path string) string {
+	return "^" + regexp.QuoteMeta(path) + "$"
+}
+
+// quoteBytes returns a Go string literal of the bytes
+// Bodies which are not valid UTF-8 are written byte by byte with \x escapes, so they are kept exactly
+func quoteBytes(data []byte) string {
+	if utf8.Valid(data) {
+		return strconv.Quote(string(data))
+	}
+	var quoted strings.Builder
+	quoted.WriteByte('"')
+	for _, b := range data {
+		fmt.Fprintf(&quoted, "\\x%02x", b)
+	}
+	quoted.WriteByte('"')
+	return quoted.String()
+}
+
+// sortedHeaderKeys returns the keys of the headers (or query) in alphabetical order
+func sortedHeaderKeys(headers http.Header) []string {
+	keys := make([]string, 0, len(headers))
+	for key := range headers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,205 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecorder(t *testing.T) {
+	t.Log("Testing record and replay...")
+
+	upstream := NewServer(NewRouter(nil).
+		WithRoute(MustNewRoute("^/users$", nil).WithMethod("GET", NewTestHandler(nil).
+			WithResponseHeader("Content-Type", "application/json").
+			WithResponseBody([]byte(`[{"id": 1}]`)))).
+		WithRoute(MustNewRoute("^/users/1$", nil).WithMethod("DELETE", NewTestHandler(nil).
+			WithResponseStatus(http.StatusNoContent))))
+	defer upstream.Close()
+
+	recorder, err := NewRecorder(upstream.URL, NewTestErrorHandler(t))
+	require.NoError(t, err, "The Recorder should be created")
+	srv := NewTestServer(t)
+	srv.Handle("^/local$", "GET", srv.Handler().WithResponseBody([]byte("Local")))
+	srv.Fallback(recorder)
+	srv.Init()
+	defer srv.Close()
+
+	for _, path := range []string{"/local", "/users", "/users"} {
+		resp, err := http.Get(srv.URL + path)
+		require.NoError(t, err, "Test server shouldn't return any errors")
+		resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode, "Response should be HTTP 200 OK")
+	}
+	request, err := http.NewRequest("DELETE", srv.URL+"/users/1", nil)
+	require.NoError(t, err, "Test request should be created")
+	resp, err := http.DefaultClient.Do(request)
+	require.NoError(t, err, "Test server shouldn't return any errors")
+	resp.Body.Close()
+
+	recordings := recorder.Recordings()
+	require.Len(t, recordings, 3, "Only the unmatched requests should be proxied and recorded")
+	require.Equal(t, "/users", recordings[0].Request.URL.Path, "The request should be recorded")
+	require.Equal(t, []byte(`[{"id": 1}]`), recordings[0].Response.Body, "The response body should be recorded")
+	require.Equal(t, http.StatusNoContent, recordings[2].Response.Status, "The response status should be recorded")
+	require.Empty(t, recordings[0].Response.Headers.Get("Content-Length"), "Framing headers should not be recorded")
+
+	dir, err := ioutil.TempDir("", "mokk")
+	require.NoError(t, err, "The temp dir should be created")
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "recorded.yml")
+	require.NoError(t, recorder.WriteDefinitionFile(file), "The definition file should be written")
+
+	replay, err := LoadRouterFromFile(file, NewTestErrorHandler(t))
+	require.NoError(t, err, "The recorded definition should be loaded")
+	require.Len(t, replay.Routes(), 2, "Repeated requests should be recorded once")
+	replaySrv := NewServer(replay)
+	defer replaySrv.Close()
+	replayResp, err := http.Get(replaySrv.URL + "/users")
+	require.NoError(t, err, "Test server shouldn't return any errors")
+	defer replayResp.Body.Close()
+	body, err := ioutil.ReadAll(replayResp.Body)
+	require.NoError(t, err, "The response body should be readable")
+	require.Equal(t, `[{"id": 1}]`, string(body), "The recorded body should be replayed")
+	require.Equal(t, "application/json", replayResp.Header.Get("Content-Type"), "The recorded headers should be replayed")
+
+	var code bytes.Buffer
+	require.NoError(t, recorder.WriteGo(&code, "mocks"), "The Go code should be written")
+	require.Equal(t,
+		"package mocks\n\n"+
+			"import \"github.com/mikloslorinczi/mokk/server\"\n\n"+
+			"// registerRecorded registers the recorded responses on the TestServer\n"+
+			"func registerRecorded(srv *server.TestServer) {\n"+
+			"\tsrv.Handle(\"^/users$\", \"GET\", srv.Handler().\n"+
+			"\t\tWithResponseStatus(200).\n"+
+			"\t\tWithResponseHeader(\"Content-Type\", \"application/json\").\n"+
+			"\t\tWithResponseBody([]byte(\"[{\\\"id\\\": 1}]\")))\n"+
+			"\tsrv.Handle(\"^/users/1$\", \"DELETE\", srv.Handler().\n"+
+			"\t\tWithResponseStatus(204))\n"+
+			"}\n",
+		code.String(),
+		"The Go code should register the recordings")
+	parsed, err := parser.ParseFile(token.NewFileSet(), "recorded.go", code.Bytes(), 0)
+	require.NoError(t, err, "The Go code should be a valid source file")
+	require.Equal(t, "mocks", parsed.Name.Name, "The Go code should have the given package")
+}
+
+func TestRecorder_binary_body(t *testing.T) {
+	t.Log("Testing record and replay of a gzip body...")
+
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	_, err := writer.Write([]byte("Compressed"))
+	require.NoError(t, err, "The body should be compressed")
+	require.NoError(t, writer.Close(), "The gzip writer should be closed")
+	gzipped := compressed.Bytes()
+	upstream := NewServer(NewRouter(nil).
+		WithRoute(MustNewRoute("^/gzip$", nil).WithMethod("GET", NewTestHandler(nil).
+			WithResponseHeader("Content-Encoding", "gzip").
+			WithResponseBody(gzipped))))
+	defer upstream.Close()
+
+	recorder, err := NewRecorder(upstream.URL, NewTestErrorHandler(t))
+	require.NoError(t, err, "The Recorder should be created")
+	srv := NewServer(NewRouter(nil).WithFallback(recorder))
+	defer srv.Close()
+	resp, err := http.Get(srv.URL + "/gzip")
+	require.NoError(t, err, "Test server shouldn't return any errors")
+	resp.Body.Close()
+	require.Equal(t, gzipped, recorder.Recordings()[0].Response.Body, "The gzip body should be recorded")
+
+	dir, err := ioutil.TempDir("", "mokk")
+	require.NoError(t, err, "The temp dir should be created")
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"recorded.yml", "recorded.json"} {
+		file := filepath.Join(dir, name)
+		require.NoError(t, recorder.WriteDefinitionFile(file), "The definition file should be written")
+		replay, err := LoadRouterFromFile(file, NewTestErrorHandler(t))
+		require.NoError(t, err, "The recorded definition should be loaded")
+		replaySrv := NewServer(replay)
+		replayResp, err := http.Get(replaySrv.URL + "/gzip")
+		require.NoError(t, err, "Test server shouldn't return any errors")
+		body, err := ioutil.ReadAll(replayResp.Body)
+		replayResp.Body.Close()
+		replaySrv.Close()
+		require.NoErrorf(t, err, "The replayed gzip body of %s should be decompressed", name)
+		require.Equalf(t, "Compressed", string(body), "The recorded gzip body of %s should be replayed", name)
+	}
+
+	var code bytes.Buffer
+	require.NoError(t, recorder.WriteGo(&code, "mocks"), "The Go code should be written")
+	literal := regexp.MustCompile(`WithResponseBody\(\[\]byte\(("[^"]*")\)\)`).FindStringSubmatch(code.String())
+	require.Len(t, literal, 2, "The Go code should have the response body")
+	unquoted, err := strconv.Unquote(literal[1])
+	require.NoError(t, err, "The response body should be a valid Go string literal")
+	require.Equal(t, gzipped, []byte(unquoted), "The Go code should keep the gzip body")
+}
+
+func TestRecorder_errors(t *testing.T) {
+	t.Log("Testing Recorder errors...")
+
+	_, err := NewRecorder("not a url", nil)
+	require.Error(t, err, "Invalid upstream URLs should fail")
+
+	recorder, err := NewRecorder("http://127.0.0.1:1", nil)
+	require.NoError(t, err, "The Recorder should be created")
+	srv := NewServer(NewRouter(nil).WithFallback(recorder))
+	defer srv.Close()
+	resp, err := http.Get(srv.URL + "/unreachable")
+	require.NoError(t, err, "Test server shouldn't return any errors")
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusBadGateway, resp.StatusCode, "Unreachable upstreams should be reported")
+	require.Empty(t, recorder.Recordings(), "Failed requests should not be recorded")
+}
+
+func TestRecorder_query(t *testing.T) {
+	t.Log("Testing recording requests with different queries...")
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.Write([]byte("page " + req.URL.Query().Get("page")))
+	}))
+	defer upstream.Close()
+	recorder, err := NewRecorder(upstream.URL, NewTestErrorHandler(t))
+	require.NoError(t, err, "The Recorder should be created")
+	srv := NewServer(NewRouter(nil).WithFallback(recorder))
+	defer srv.Close()
+
+	paths := []string{"/users?page=2", "/users", "/users?page=1", "/users?page=2"}
+	for _, path := range paths {
+		resp, err := http.Get(srv.URL + path)
+		require.NoError(t, err, "Test server shouldn't return any errors")
+		resp.Body.Close()
+	}
+	definition := recorder.Definition()
+	require.Len(t, definition.Routes, 3, "Every query should be recorded once")
+	require.Empty(t, definition.Routes[0].Request.Query, "The request without query should be the first")
+	require.Equal(t, Values{"page": {"2"}}, definition.Routes[1].Request.Query,
+		"The query should be required")
+
+	replay, err := definition.Router(nil)
+	require.NoError(t, err, "The recorded definition should be loaded")
+	replaySrv := NewServer(replay)
+	defer replaySrv.Close()
+	for _, path := range paths {
+		_, body := adminRequest(t, "GET", replaySrv.URL+path, "")
+		expected, _ := url.ParseQuery(strings.TrimPrefix(path, "/users?"))
+		require.Equalf(t, "page "+expected.Get("page"), string(body), "The response of %s should be replayed", path)
+	}
+
+	var code bytes.Buffer
+	require.NoError(t, recorder.WriteGo(&code, "mocks"), "The Go code should be written")
+	require.Contains(t, code.String(), `WithQueryParam("page", "1")`, "The Go code should require the query")
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+// Response is a predefined HTTP response of a TestHandler
+// Its Headers are written in addition to the TestHandler's response headers
+type Response struct {
+	Status  int
+	Headers http.Header
+	Body    []byte
+}
+
+// ExhaustedBehavior tells what a TestHandler does when its response sequence is exhausted
+type ExhaustedBehavior int
+
+const (
+	// RepeatLast keeps returning the last response of the sequence
+	RepeatLast ExhaustedBehavior = iota
+	// Cycle starts the sequence over from the first response
+	Cycle
+	// FailWhenExhausted calls the ErrorHandler with HTTP 500 Internal Server Error
+	FailWhenExhausted
+)
+
+// WithResponseSequence sets an ordered sequence of responses on the TestHandler and returns it
+// The nth call of the TestHandler returns the nth response, instead of the TestHandler's status and body
+func (handler *TestHandler) WithResponseSequence(responses ...Response) *TestHandler {
+	handler.responses = responses
+	return handler
+}
+
+// AddResponseSequence sets an ordered sequence of responses on the TestHandler
+// The nth call of the TestHandler returns the nth response, instead of the TestHandler's status and body
+func (handler *TestHandler) AddResponseSequence(responses ...Response) {
+	handler.responses = responses
+}
+
+// WithExhaustedBehavior sets what the TestHandler does after its response sequence is exhausted and returns it
+// The default is RepeatLast
+func (handler *TestHandler) WithExhaustedBehavior(behavior ExhaustedBehavior) *TestHandler {
+	handler.exhausted = behavior
+	return handler
+}
+
+// AddExhaustedBehavior sets what the TestHandler does after its response sequence is exhausted
+// The default is RepeatLast
+func (handler *TestHandler) AddExhaustedBehavior(behavior ExhaustedBehavior) {
+	handler.exhausted = behavior
+}
+
+// nextResponse returns the response for the given 1-based call number
+// Without a response sequence it is the TestHandler's own status and body
+func (handler *TestHandler) nextResponse(call int) (Response, error) {
+	count := len(handler.responses)
+	if count == 0 {
+		return Response{Status: handler.responseStatus, Body: handler.responseBody}, nil
+	}
+	if call <= count {
+		return handler.responses[call-1], nil
+	}
+	switch handler.exhausted {
+	case Cycle:
+		return handler.responses[(call-1)%count], nil
+	case FailWhenExhausted:
+		return Response{}, errors.Errorf("Response sequence exhausted: call %d of %d responses", call, count)
+	default:
+		return handler.responses[count-1], nil
+	}
+}
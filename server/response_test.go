@@ -0,0 +1,79 @@
+package server
+
+import (
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestResponseSequence(t *testing.T) {
+	t.Log("Testing response sequence...")
+
+	srv := NewTestServer(t)
+	srv.Handle("^/test/retry$", "GET", srv.Handler().
+		WithResponseHeader("Route", "retry").
+		WithResponseSequence(
+			Response{Status: http.StatusServiceUnavailable},
+			Response{Status: http.StatusServiceUnavailable},
+			Response{Status: http.StatusOK, Headers: http.Header{"Page": {"1"}}, Body: []byte("Done")},
+		))
+	srv.Init()
+	defer srv.Close()
+
+	expected := []int{
+		http.StatusServiceUnavailable,
+		http.StatusServiceUnavailable,
+		http.StatusOK,
+		http.StatusOK,
+	}
+	for i, status := range expected {
+		resp, err := http.Get(srv.URL + "/test/retry")
+		require.NoError(t, err, "Test server shouldn't return any errors")
+		require.Equalf(t, status, resp.StatusCode, "Response %d should have status %d", i+1, status)
+		require.Equal(t, "retry", resp.Header.Get("Route"), "The TestHandler's headers should be written")
+		resp.Body.Close()
+	}
+
+	resp, err := http.Get(srv.URL + "/test/retry")
+	require.NoError(t, err, "Test server shouldn't return any errors")
+	defer resp.Body.Close()
+	respBody, readErr := ioutil.ReadAll(resp.Body)
+	require.NoError(t, readErr, "The response body should be readable")
+	require.Equal(t, []byte("Done"), respBody, "The last response should be repeated")
+	require.Equal(t, "1", resp.Header.Get("Page"), "The response's headers should be written")
+}
+
+func TestResponseSequence_exhausted(t *testing.T) {
+	t.Log("Testing exhausted response sequences...")
+
+	cycle := NewTestHandler(nil).
+		WithResponseSequence(Response{Status: http.StatusAccepted}, Response{Status: http.StatusCreated}).
+		WithExhaustedBehavior(Cycle)
+	var statuses []int
+	for call := 1; call <= 4; call++ {
+		response, err := cycle.nextResponse(call)
+		require.NoError(t, err, "A cycling sequence should never be exhausted")
+		statuses = append(statuses, response.Status)
+	}
+	require.Equal(t,
+		[]int{http.StatusAccepted, http.StatusCreated, http.StatusAccepted, http.StatusCreated},
+		statuses,
+		"The sequence should start over")
+
+	handler := NewTestHandler(nil).WithResponseSequence(Response{Status: http.StatusAccepted})
+	handler.AddExhaustedBehavior(FailWhenExhausted)
+	srv := NewServer(handler)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	require.NoError(t, err, "Test server shouldn't return any errors")
+	resp.Body.Close()
+	require.Equal(t, http.StatusAccepted, resp.StatusCode, "The first response should be returned")
+
+	failResp, failErr := http.Get(srv.URL)
+	require.NoError(t, failErr, "Test server shouldn't return any errors")
+	defer failResp.Body.Close()
+	require.Equal(t, http.StatusInternalServerError, failResp.StatusCode, "An exhausted sequence should fail")
+}
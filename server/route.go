@@ -2,6 +2,9 @@ package server
 
 import (
 	"net/http"
+	"regexp"
+	"sort"
+	"sync"
 
 	"github.com/pkg/errors"
 )
@@ -9,47 +12,139 @@ import (
 // Route is a Handler matched by a regex
 // it routes to handlers with different methods
 // its ErrorHandler will be called if no handler matches with the requested method
+// A method can have multiple handlers, the one with the highest priority which applies to the request handles it,
+// from the most recently added one (see Conditional and Prioritized),
+// so unconditional handlers replace the previous ones with the same or lower priority
+//
+// The methods can be added or removed safely while the Route is serving requests
 type Route struct {
-	regex        string
-	methods      map[string]http.Handler
+	regex        *regexp.Regexp
+	methods      map[string][]http.Handler
+	methodsMutex sync.RWMutex
 	errorHandler ErrorHandler
 }
 
-// NewRoute creates a new Router with the given regex and ErrorHandler, and returns its pointer
+// NewRoute creates a new Route with the given regex and ErrorHandler, and returns its pointer
+// The regex is compiled once, an error is returned if it is invalid
 // If nil ErrorHandler is provided it will fall back to the BasicErrorHandler
-func NewRoute(pathRegex string, errHandler ErrorHandler) *Route {
+func NewRoute(pathRegex string, errHandler ErrorHandler) (*Route, error) {
+	regex, err := regexp.Compile(pathRegex)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Invalid route regex %q", pathRegex)
+	}
 	var handler ErrorHandler = &BasicErrorHandler{}
 	if errHandler != nil {
 		handler = errHandler
 	}
 	return &Route{
-		regex:        pathRegex,
-		methods:      make(map[string]http.Handler),
+		regex:        regex,
+		methods:      make(map[string][]http.Handler),
 		errorHandler: handler,
+	}, nil
+}
+
+// MustNewRoute is like NewRoute but panics if the regex is invalid
+func MustNewRoute(pathRegex string, errHandler ErrorHandler) *Route {
+	route, err := NewRoute(pathRegex, errHandler)
+	if err != nil {
+		panic(err)
 	}
+	return route
+}
+
+// Regex returns the source text of the Route's regex
+func (route *Route) Regex() string {
+	return route.regex.String()
 }
 
 // WithMethod adds the given Handler with the given method to the Route and returns it
 func (route *Route) WithMethod(method string, handler http.Handler) *Route {
-	route.methods[method] = handler
+	route.AddMethod(method, handler)
 	return route
 }
 
 // AddMethod adds the given Handler with the given method to the Route
+// If the Handler applies to every request (it is not Conditional, or a TestHandler without requirements)
+// the method's Handlers it is tried before are removed, as they could never handle a request
+// (TestServer.AssertExpectations still checks their call count expectations)
 func (route *Route) AddMethod(method string, handler http.Handler) {
-	route.methods[method] = handler
+	route.methodsMutex.Lock()
+	defer route.methodsMutex.Unlock()
+	if !alwaysApplies(handler) {
+		route.methods[method] = append(route.methods[method], handler)
+		return
+	}
+	// A new slice is used, so the handlers read by ServeHTTP before are not affected
+	var kept []http.Handler
+	for _, previous := range route.methods[method] {
+		if priority(previous) > priority(handler) {
+			kept = append(kept, previous)
+		}
+	}
+	route.methods[method] = append(kept, handler)
+}
+
+// RemoveMethod removes all the Handlers of the given method from the Route
+// It returns false if the Route has no Handler with the given method
+func (route *Route) RemoveMethod(method string) bool {
+	route.methodsMutex.Lock()
+	defer route.methodsMutex.Unlock()
+	_, ok := route.methods[method]
+	delete(route.methods, method)
+	return ok
+}
+
+// Methods returns the methods the Route has Handlers for, in alphabetical order
+func (route *Route) Methods() []string {
+	route.methodsMutex.RLock()
+	defer route.methodsMutex.RUnlock()
+	methods := make([]string, 0, len(route.methods))
+	for method := range route.methods {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	return methods
+}
+
+// Handlers returns the Route's Handlers by method, in the order they were added
+func (route *Route) Handlers() map[string][]http.Handler {
+	route.methodsMutex.RLock()
+	defer route.methodsMutex.RUnlock()
+	handlers := make(map[string][]http.Handler, len(route.methods))
+	for method, methodHandlers := range route.methods {
+		handlers[method] = append([]http.Handler(nil), methodHandlers...)
+	}
+	return handlers
 }
 
 // ServeHTTP
 // The Route will try to match the request's method with its know methods and
 // pass the request accordingly, if no matching method is find it will call
-// the ErrorHandler with HTTP 405 MethodNotAllowed
+// the ErrorHandler with HTTP 405 MethodNotAllowed, listing the Route's handlers and why they did not match
+// If the method has multiple handlers the first one which applies to the request is called (see Conditional),
+// if none applies the ErrorHandler is called with the closest matches and their mismatches
 func (route *Route) ServeHTTP(res http.ResponseWriter, req *http.Request) {
-	for method, handler := range route.methods {
-		if req.Method == method {
-			handler.ServeHTTP(res, req)
-			return
-		}
+	// AddMethod only appends after the length read here or uses a new slice
+	// and RemoveMethod deletes the whole slice, so it is safe to use without copying
+	route.methodsMutex.RLock()
+	handlers := route.methods[req.Method]
+	route.methodsMutex.RUnlock()
+	if len(handlers) == 0 {
+		route.errorHandler.HandleError(
+			res,
+			req,
+			http.StatusMethodNotAllowed,
+			errors.Errorf("Method not allowed%s", formatNearMisses(rankNearMisses(route.nearMisses(req, "")))))
+		return
+	}
+	if len(handlers) == 1 {
+		handlers[0].ServeHTTP(res, req)
+		return
+	}
+	handler, mismatch := route.applicableHandler(handlers, req)
+	if mismatch != nil {
+		route.errorHandler.HandleError(res, req, mismatch.Status, mismatch)
+		return
 	}
-	route.errorHandler.HandleError(res, req, http.StatusMethodNotAllowed, errors.New("Method not allowed"))
+	handler.ServeHTTP(res, withSelectedHandler(req, handler))
 }
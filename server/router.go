@@ -3,20 +3,33 @@ package server
 import (
 	"net/http"
 	"regexp"
+	"strings"
+	"sync"
 
 	"github.com/pkg/errors"
 )
 
 // Router is a Handler with a list of Routes and an ErrorHandler
-// It tries to match the request's URL with its routes using the route's regex
+// It tries to match the request's URL path with its routes using the route's regex
+// (or the whole URL including the query string, if full URL matching is enabled)
 // If a match found the request will be passed to the route
 // If no match found the ErrorHandler will be called with HTTP 404 Not Found
 //
 // As the Router checks its routes in order it is best to declare them:
 // from more specific ones to less specific ones
+//
+// The routes can be added or replaced safely while the Router is serving requests
 type Router struct {
 	routes       []*Route
+	routesMutex  sync.RWMutex
 	errorHandler ErrorHandler
+	journal      *Journal
+	matchFullURL bool
+	admin        *Admin
+	fallback     http.Handler
+
+	scenarios      map[string]*Scenario
+	scenariosMutex sync.Mutex
 }
 
 // NewRouter creates a new Router with the given ErrorHandler and return its pointer
@@ -32,51 +45,221 @@ func NewRouter(errHandler ErrorHandler) *Router {
 
 // WithRoute adds a Route to the Router and returns it
 func (router *Router) WithRoute(route *Route) *Router {
-	router.routes = append(router.routes, route)
+	router.AddRoute(route)
 	return router
 }
 
 // WithRoutes adds all Routes to the Router and returns it
 func (router *Router) WithRoutes(routes ...*Route) *Router {
-	router.routes = append(router.routes, routes...)
+	router.AddRoutes(routes...)
 	return router
 }
 
 // AddRoute adds a Route to the Router
 func (router *Router) AddRoute(route *Route) {
-	router.routes = append(router.routes, route)
+	router.AddRoutes(route)
 }
 
 // AddRoutes adds all the Routes to the Router
 func (router *Router) AddRoutes(routes ...*Route) {
+	router.routesMutex.Lock()
+	defer router.routesMutex.Unlock()
 	router.routes = append(router.routes, routes...)
 }
 
+// ReplaceRoutes atomically replaces all the Routes of the Router
+// Requests already passed to a previous Route are not affected
+func (router *Router) ReplaceRoutes(routes ...*Route) {
+	replacement := make([]*Route, len(routes))
+	copy(replacement, routes)
+	router.routesMutex.Lock()
+	defer router.routesMutex.Unlock()
+	router.routes = replacement
+}
+
+// Handle adds the Handler with the given method to the Route with the given regex
+// If the Router has no such Route a new one is added with the Router's ErrorHandler
+// It returns an error if the regex or the Handler's options are invalid (see TestHandler.Err)
+func (router *Router) Handle(pathRegex, method string, handler http.Handler) error {
+	_, err := router.handle(pathRegex, method, handler)
+	return err
+}
+
+// handle is like Handle but also returns the Route the Handler was added to
+func (router *Router) handle(pathRegex, method string, handler http.Handler) (*Route, error) {
+	if validated, ok := handler.(validated); ok {
+		if err := validated.Err(); err != nil {
+			return nil, err
+		}
+	}
+	router.routesMutex.Lock()
+	defer router.routesMutex.Unlock()
+	for _, route := range router.routes {
+		if route.Regex() == pathRegex {
+			route.AddMethod(method, handler)
+			return route, nil
+		}
+	}
+	route, err := NewRoute(pathRegex, router.errorHandler)
+	if err != nil {
+		return nil, err
+	}
+	router.routes = append(router.routes, route.WithMethod(method, handler))
+	return route, nil
+}
+
+// RemoveRoute removes the Route with the given regex from the Router
+// It returns false if the Router has no such Route
+func (router *Router) RemoveRoute(pathRegex string) bool {
+	router.routesMutex.Lock()
+	defer router.routesMutex.Unlock()
+	for i, route := range router.routes {
+		if route.Regex() == pathRegex {
+			// A new slice is used, so snapshots taken before are not affected
+			routes := make([]*Route, 0, len(router.routes)-1)
+			router.routes = append(append(routes, router.routes[:i]...), router.routes[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Route returns the Route with the given regex, or nil if the Router has no such Route
+func (router *Router) Route(pathRegex string) *Route {
+	for _, route := range router.snapshot() {
+		if route.Regex() == pathRegex {
+			return route
+		}
+	}
+	return nil
+}
+
+// Routes returns a snapshot of the Router's Routes in order
+func (router *Router) Routes() []*Route {
+	router.routesMutex.RLock()
+	defer router.routesMutex.RUnlock()
+	routes := make([]*Route, len(router.routes))
+	copy(routes, router.routes)
+	return routes
+}
+
+// WithFallback sets the Handler of the requests which match none of the Router's Routes and returns it
+func (router *Router) WithFallback(handler http.Handler) *Router {
+	router.fallback = handler
+	return router
+}
+
+// AddFallback sets the Handler of the requests which match none of the Router's Routes
+func (router *Router) AddFallback(handler http.Handler) {
+	router.fallback = handler
+}
+
+// snapshot returns the current Routes without copying them
+// It is safe, because AddRoutes and Handle only append after the snapshot's length
+// and ReplaceRoutes and RemoveRoute use a new slice
+func (router *Router) snapshot() []*Route {
+	router.routesMutex.RLock()
+	defer router.routesMutex.RUnlock()
+	return router.routes
+}
+
+// WithJournal sets the Journal the Router records its requests into and returns it
+func (router *Router) WithJournal(journal *Journal) *Router {
+	router.journal = journal
+	return router
+}
+
+// AddJournal sets the Journal the Router records its requests into
+func (router *Router) AddJournal(journal *Journal) {
+	router.journal = journal
+}
+
+// WithFullURLMatching sets whether the Router matches its Routes against the whole URL
+// instead of the URL path only, and returns it
+func (router *Router) WithFullURLMatching(enabled bool) *Router {
+	router.matchFullURL = enabled
+	return router
+}
+
+// AddFullURLMatching sets whether the Router matches its Routes against the whole URL
+// instead of the URL path only
+func (router *Router) AddFullURLMatching(enabled bool) {
+	router.matchFullURL = enabled
+}
+
 // ServeHTTP
-// The Router will try to match the request's URL with its Route's regex
-// If a match it will pass the request to the matching Route
-// If no match found the Router's ErrorHandler will be called with HTTP 404 Not Found
+// The Router will try to match the request's URL path with its Route's regex
+// If a match it will pass the request to the matching Route, with the named capture groups
+// of the Route's regex in the request's context (see PathParam)
+// If no match found the request will be passed to the Router's fallback Handler,
+// or if it has none the Router's ErrorHandler will be called with HTTP 404 Not Found,
+// listing the handlers closest to the request and why they did not match
+// If the Router has a Journal every request is recorded before it is handled
+// Requests under the Admin API's prefix are passed to the Admin, they are not recorded
 func (router *Router) ServeHTTP(res http.ResponseWriter, req *http.Request) {
-	for _, route := range router.routes {
-		if urlMatch(req.URL.String(), route.regex) {
-			route.ServeHTTP(res, req)
-			return
+	if router.admin != nil && strings.HasPrefix(req.URL.Path, router.admin.prefix) {
+		router.admin.ServeHTTP(res, req)
+		return
+	}
+	target := req.URL.Path
+	if router.matchFullURL {
+		target = req.URL.String()
+	}
+	var matched *Route
+	var params map[string]string
+	for _, route := range router.snapshot() {
+		if routeParams, ok := urlMatch(target, route.regex); ok {
+			matched, params = route, routeParams
+			break
 		}
 	}
+	if !router.record(res, req, matched) {
+		return
+	}
+	if matched != nil {
+		matched.ServeHTTP(res, withPathParams(req, params))
+		return
+	}
+	if router.fallback != nil {
+		router.fallback.ServeHTTP(res, req)
+		return
+	}
 	router.errorHandler.HandleError(
 		res,
 		req,
 		http.StatusNotFound,
-		errors.Errorf("Not found: %s", req.URL.String()))
+		errors.Errorf("Not found: %s%s", req.URL.String(), formatNearMisses(nearMisses(router.snapshot(), req, target))))
 }
 
-// urlMatch is a helper function that matches the given string against the given regex
-// It will return true if it matches, and false if the check fails or it does not matches
-// TODO: the fail case could be an internal server error
-func urlMatch(url, regex string) bool {
-	matched, err := regexp.MatchString(regex, url)
+// record saves the request into the Router's Journal, if it has one
+// It returns false if the request could not be recorded, in this case the ErrorHandler is already called
+func (router *Router) record(res http.ResponseWriter, req *http.Request, matched *Route) bool {
+	if router.journal == nil {
+		return true
+	}
+	var matchedRoute string
+	if matched != nil {
+		matchedRoute = matched.Regex()
+	}
+	request, err := recordRequest(req, matchedRoute)
 	if err != nil {
+		router.errorHandler.HandleError(
+			res,
+			req,
+			http.StatusInternalServerError,
+			errors.Wrap(err, "Cannot read request body"))
 		return false
 	}
-	return matched
+	router.journal.Record(request)
+	return true
+}
+
+// urlMatch is a helper function that matches the given string against the given regex
+// It will return the named capture groups and true if it matches, and false if it does not matches
+func urlMatch(url string, regex *regexp.Regexp) (map[string]string, bool) {
+	match := regex.FindStringSubmatch(url)
+	if match == nil {
+		return nil, false
+	}
+	return namedGroups(regex, match), true
 }
@@ -31,7 +31,8 @@ func TestMultiMethod(t *testing.T) {
 func TestAddingRoutes(t *testing.T) {
 	errHandler := NewTestErrorHandler(t)
 	handler := NewTestHandler(errHandler)
-	route := NewRoute("^/test$", errHandler)
+	route, routeErr := NewRoute("^/test$", errHandler)
+	require.NoError(t, routeErr, "The route should be created")
 	route.AddMethod("GET", handler)
 	router := NewRouter(errHandler)
 	router.AddRoute(route)
@@ -84,3 +85,84 @@ func TestOrder(t *testing.T) {
 	require.Equal(t, "Not Found", resp3.Header.Get("Route"), "Response should have the header Route:/test/api")
 	defer resp3.Body.Close()
 }
+
+func TestPathParams(t *testing.T) {
+	t.Log("Testing path parameters")
+
+	srv := NewTestServer(t)
+	srv.Handle(`^/users/(?P<id>\d+)/posts/(?P<post>\w+)$`, "GET", http.HandlerFunc(
+		func(res http.ResponseWriter, req *http.Request) {
+			res.Header().Set("User", PathParam(req, "id"))
+			res.Header().Set("Post", PathParam(req, "post"))
+			res.Header().Set("Missing", PathParam(req, "missing"))
+		}))
+	srv.Handle(`^/users/(?P<id>\d+)$`, "GET", srv.Handler().WithPathParam("id", "42"))
+	srv.Init()
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/users/42/posts/first")
+	require.NoError(t, err, "Test server shouldn't return any errors")
+	defer resp.Body.Close()
+	require.Equal(t, "42", resp.Header.Get("User"), "The id path param should be extracted")
+	require.Equal(t, "first", resp.Header.Get("Post"), "The post path param should be extracted")
+	require.Empty(t, resp.Header.Get("Missing"), "Unknown path params should be empty")
+
+	userResp, userErr := http.Get(srv.URL + "/users/42")
+	require.NoError(t, userErr, "Test server shouldn't return any errors")
+	defer userResp.Body.Close()
+	require.Equal(t, http.StatusOK, userResp.StatusCode, "The required path param should match")
+}
+
+func TestPathParams_mismatch(t *testing.T) {
+	t.Log("Testing path parameter mismatch")
+
+	route := MustNewRoute(`^/users/(?P<id>\d+)$`, nil).WithMethod("GET", NewTestHandler(nil).WithPathParam("id", "42"))
+	srv := NewServer(NewRouter(nil).WithRoute(route))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/users/7")
+	require.NoError(t, err, "Test server shouldn't return any errors")
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode, "Response should be HTTP 400 Bad Request")
+}
+
+func TestNewRoute_invalid_regex(t *testing.T) {
+	t.Log("Testing invalid route regex")
+
+	route, err := NewRoute("/users/(", nil)
+	require.Error(t, err, "NewRoute should return an error on invalid regex")
+	require.Nil(t, route, "NewRoute shouldn't return a Route on invalid regex")
+	require.Panics(t, func() { MustNewRoute("/users/(", nil) }, "MustNewRoute should panic on invalid regex")
+}
+
+func TestReplaceRoutes(t *testing.T) {
+	t.Log("Testing replacing routes while serving")
+
+	router := NewRouter(nil).WithRoute(MustNewRoute("^/old$", nil).WithMethod("GET", NewTestHandler(nil)))
+	srv := NewServer(router)
+	defer srv.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 20; i++ {
+			resp, err := http.Get(srv.URL + "/old")
+			if err == nil {
+				resp.Body.Close()
+			}
+		}
+	}()
+	router.ReplaceRoutes(MustNewRoute("^/new$", nil).WithMethod("GET", NewTestHandler(nil)))
+	<-done
+
+	require.Len(t, router.Routes(), 1, "The routes should be replaced")
+	resp, err := http.Get(srv.URL + "/new")
+	require.NoError(t, err, "Test server shouldn't return any errors")
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode, "The new route should be served")
+
+	oldResp, err := http.Get(srv.URL + "/old")
+	require.NoError(t, err, "Test server shouldn't return any errors")
+	defer oldResp.Body.Close()
+	require.Equal(t, http.StatusNotFound, oldResp.StatusCode, "The old route should be removed")
+}
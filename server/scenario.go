@@ -0,0 +1,172 @@
+package server
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// StartedState is the state every Scenario starts in
+const StartedState = "Started"
+
+// Scenario is a named state machine shared by TestHandlers, to model flows across routes
+// like "GET /orders/1 returns 404 until POST /orders is called"
+// A TestHandler can require the Scenario to be in a state (see TestHandler.WithRequiredState)
+// and move it to a new state after responding (see TestHandler.WithNewState)
+//
+// Checking the required state and moving to the new one is not atomic,
+// concurrent requests of the same Scenario should not rely on each other's transitions
+type Scenario struct {
+	name  string
+	state string
+	mutex sync.Mutex
+}
+
+// ScenarioInfo describes a Scenario in the Admin API
+type ScenarioInfo struct {
+	Name  string `json:"name"`
+	State string `json:"state"`
+}
+
+// NewScenario creates a new Scenario with the given name in the StartedState and returns its pointer
+func NewScenario(name string) *Scenario {
+	return &Scenario{name: name, state: StartedState}
+}
+
+// Name returns the name of the Scenario
+func (scenario *Scenario) Name() string {
+	return scenario.name
+}
+
+// State returns the current state of the Scenario
+func (scenario *Scenario) State() string {
+	scenario.mutex.Lock()
+	defer scenario.mutex.Unlock()
+	return scenario.state
+}
+
+// SetState moves the Scenario to the given state
+func (scenario *Scenario) SetState(state string) {
+	scenario.mutex.Lock()
+	defer scenario.mutex.Unlock()
+	scenario.state = state
+}
+
+// Reset moves the Scenario back to the StartedState
+func (scenario *Scenario) Reset() {
+	scenario.SetState(StartedState)
+}
+
+// scenarioState is a state of a Scenario
+type scenarioState struct {
+	scenario *Scenario
+	state    string
+}
+
+// WithRequiredState sets the TestHandler to apply only while the Scenario is in the given state and returns it
+func (handler *TestHandler) WithRequiredState(scenario *Scenario, state string) *TestHandler {
+	handler.AddRequiredState(scenario, state)
+	return handler
+}
+
+// AddRequiredState sets the TestHandler to apply only while the Scenario is in the given state
+func (handler *TestHandler) AddRequiredState(scenario *Scenario, state string) {
+	handler.requiredState = &scenarioState{scenario: scenario, state: state}
+}
+
+// WithNewState sets the TestHandler to move the Scenario to the given state after responding and returns it
+func (handler *TestHandler) WithNewState(scenario *Scenario, state string) *TestHandler {
+	handler.AddNewState(scenario, state)
+	return handler
+}
+
+// AddNewState sets the TestHandler to move the Scenario to the given state after responding
+func (handler *TestHandler) AddNewState(scenario *Scenario, state string) {
+	handler.newState = &scenarioState{scenario: scenario, state: state}
+}
+
+// checkState returns an error if the TestHandler's required Scenario state is not the current one
+func (handler *TestHandler) checkState() error {
+	required := handler.requiredState
+	if required == nil {
+		return nil
+	}
+	if actual := required.scenario.State(); actual != required.state {
+		return errors.Errorf("scenario %q: expected state %q got %q", required.scenario.Name(), required.state, actual)
+	}
+	return nil
+}
+
+// transition moves the TestHandler's Scenario to its new state, if it has one
+func (handler *TestHandler) transition() {
+	if handler.newState != nil {
+		handler.newState.scenario.SetState(handler.newState.state)
+	}
+}
+
+// Scenario returns the Router's Scenario with the given name, creating it if it does not exist yet
+// Mock definitions and the Admin API refer to the Router's Scenarios by name
+func (router *Router) Scenario(name string) *Scenario {
+	router.scenariosMutex.Lock()
+	defer router.scenariosMutex.Unlock()
+	if router.scenarios == nil {
+		router.scenarios = make(map[string]*Scenario)
+	}
+	scenario, ok := router.scenarios[name]
+	if !ok {
+		scenario = NewScenario(name)
+		router.scenarios[name] = scenario
+	}
+	return scenario
+}
+
+// Scenarios returns the Router's Scenarios in alphabetical order of their names
+func (router *Router) Scenarios() []*Scenario {
+	router.scenariosMutex.Lock()
+	defer router.scenariosMutex.Unlock()
+	scenarios := make([]*Scenario, 0, len(router.scenarios))
+	for _, scenario := range router.scenarios {
+		scenarios = append(scenarios, scenario)
+	}
+	sort.Slice(scenarios, func(i, j int) bool { return scenarios[i].name < scenarios[j].name })
+	return scenarios
+}
+
+// ReplaceScenarios replaces all the Router's Scenarios with the given ones,
+// e.g. with the Scenarios of another Router whose Routes were taken over by ReplaceRoutes
+func (router *Router) ReplaceScenarios(scenarios ...*Scenario) {
+	replaced := scenarioMap(scenarios)
+	router.scenariosMutex.Lock()
+	defer router.scenariosMutex.Unlock()
+	router.scenarios = replaced
+}
+
+// ReplaceWith atomically replaces all the Routes and Scenarios of the Router with the ones of the other Router,
+// so no request sees the new Routes with the old Scenarios or the other way around
+// Requests already passed to a previous Route are not affected
+func (router *Router) ReplaceWith(other *Router) {
+	routes, scenarios := other.Routes(), scenarioMap(other.Scenarios())
+	router.routesMutex.Lock()
+	defer router.routesMutex.Unlock()
+	router.scenariosMutex.Lock()
+	defer router.scenariosMutex.Unlock()
+	router.routes = routes
+	router.scenarios = scenarios
+}
+
+// scenarioMap indexes the Scenarios by their names
+func scenarioMap(scenarios []*Scenario) map[string]*Scenario {
+	indexed := make(map[string]*Scenario, len(scenarios))
+	for _, scenario := range scenarios {
+		indexed[scenario.name] = scenario
+	}
+	return indexed
+}
+
+// ResetScenarios moves all the Router's Scenarios back to the StartedState
+func (router *Router) ResetScenarios() {
+	for _, scenario := range router.Scenarios() {
+		scenario.Reset()
+	}
+}
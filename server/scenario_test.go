@@ -0,0 +1,154 @@
+package server
+
+import (
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestScenario(t *testing.T) {
+	t.Log("Testing stateful scenarios across routes...")
+
+	srv := NewTestServer(t)
+	orders := srv.Scenario("orders")
+	require.Equal(t, StartedState, orders.State(), "Scenarios should start in the Started state")
+	require.Equal(t, orders, srv.Scenario("orders"), "Scenarios should be shared by name")
+
+	srv.Handle("^/orders$", "POST", srv.Handler().
+		WithResponseStatus(http.StatusCreated).
+		WithNewState(orders, "Created"))
+	srv.Handle("^/orders/1$", "GET", srv.Handler().
+		WithRequiredState(orders, StartedState).
+		WithResponseStatus(http.StatusNotFound))
+	srv.Handle("^/orders/1$", "GET", srv.Handler().
+		WithRequiredState(orders, "Created").
+		WithResponseBody([]byte("Order 1")))
+	srv.Init()
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/orders/1")
+	require.NoError(t, err, "Test server shouldn't return any errors")
+	resp.Body.Close()
+	require.Equal(t, http.StatusNotFound, resp.StatusCode, "The order should not exist before it is created")
+
+	resp, err = http.Post(srv.URL+"/orders", "application/json", nil)
+	require.NoError(t, err, "Test server shouldn't return any errors")
+	resp.Body.Close()
+	require.Equal(t, http.StatusCreated, resp.StatusCode, "The order should be created")
+	require.Equal(t, "Created", orders.State(), "The scenario should move to the new state")
+
+	resp, err = http.Get(srv.URL + "/orders/1")
+	require.NoError(t, err, "Test server shouldn't return any errors")
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err, "Response body should be read")
+	require.Equal(t, http.StatusOK, resp.StatusCode, "The order should exist after it is created")
+	require.Equal(t, "Order 1", string(body), "The handler of the current state should respond")
+
+	orders.Reset()
+	require.Equal(t, StartedState, orders.State(), "Reset should move the scenario back to Started")
+}
+
+func TestScenario_handler_choice(t *testing.T) {
+	t.Log("Testing the choice among multiple handlers of the same method...")
+
+	scenario := NewScenario("flow")
+	first := NewTestHandler(nil).WithResponseStatus(http.StatusAccepted)
+	route := MustNewRoute("^/flow$", nil).
+		WithMethod("GET", first).
+		WithMethod("GET", NewTestHandler(nil).WithRequiredState(scenario, "Other"))
+	srv := NewServer(NewRouter(nil).WithRoute(route))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/flow")
+	require.NoError(t, err, "Test server shouldn't return any errors")
+	resp.Body.Close()
+	require.Equal(t, http.StatusAccepted, resp.StatusCode, "An applicable earlier handler should respond")
+
+	route.AddMethod("GET", NewTestHandler(nil).WithResponseStatus(http.StatusNoContent))
+	resp, err = http.Get(srv.URL + "/flow")
+	require.NoError(t, err, "Test server shouldn't return any errors")
+	resp.Body.Close()
+	require.Equal(t, http.StatusNoContent, resp.StatusCode, "The most recently added handler should respond")
+	require.Len(t, route.Handlers()["GET"], 1, "The handlers which can no longer respond should be dropped")
+
+	scenarioRoute := MustNewRoute("^/state$", nil).
+		WithMethod("GET", NewTestHandler(nil).WithRequiredState(scenario, "Other"))
+	stateSrv := NewServer(NewRouter(nil).WithRoute(scenarioRoute))
+	defer stateSrv.Close()
+	resp, err = http.Get(stateSrv.URL + "/state")
+	require.NoError(t, err, "Test server shouldn't return any errors")
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err, "Response body should be read")
+	require.Equal(t, http.StatusNotFound, resp.StatusCode, "Requests no handler applies to should return HTTP 404")
+	require.Contains(t, string(body), `scenario "flow": expected state "Other" got "Started"`,
+		"The reason should be reported")
+}
+
+func TestRouter_Scenarios(t *testing.T) {
+	t.Log("Testing the Router's scenarios...")
+
+	router := NewRouter(nil)
+	router.Scenario("b").SetState("Done")
+	router.Scenario("a")
+	scenarios := router.Scenarios()
+	require.Len(t, scenarios, 2, "Every scenario should be returned")
+	require.Equal(t, "a", scenarios[0].Name(), "Scenarios should be sorted by name")
+
+	router.ResetScenarios()
+	require.Equal(t, StartedState, router.Scenario("b").State(), "Every scenario should be reset")
+
+	replacement := NewScenario("c")
+	router.ReplaceScenarios(replacement)
+	require.Equal(t, []*Scenario{replacement}, router.Scenarios(), "The scenarios should be replaced")
+
+	other := NewRouter(nil).WithRoute(MustNewRoute("^/other$", nil))
+	other.Scenario("d")
+	router.ReplaceWith(other)
+	require.Equal(t, other.Routes(), router.Routes(), "The routes should be replaced")
+	require.Equal(t, other.Scenarios(), router.Scenarios(), "The scenarios should be replaced with the routes")
+}
+
+func TestScenario_definition(t *testing.T) {
+	t.Log("Testing scenarios in mock definitions and the Admin API...")
+
+	definition, err := ParseDefinition([]byte(`
+routes:
+  - regex: ^/login$
+    method: POST
+    scenario: session
+    newState: LoggedIn
+  - regex: ^/me$
+    scenario: session
+    requiredState: LoggedIn
+    response: {body: me}
+`))
+	require.NoError(t, err, "The definition should be parsed")
+	router, err := definition.Router(nil)
+	require.NoError(t, err, "The Router should be created")
+	srv := NewServer(router.WithAdmin("/__mokk/"))
+	defer srv.Close()
+
+	status, _ := adminRequest(t, "GET", srv.URL+"/me", "")
+	require.Equal(t, http.StatusNotFound, status, "The handler should not apply before logging in")
+	status, _ = adminRequest(t, "POST", srv.URL+"/login", "")
+	require.Equal(t, http.StatusOK, status, "The login should succeed")
+	status, body := adminRequest(t, "GET", srv.URL+"/me", "")
+	require.Equal(t, http.StatusOK, status, "The handler should apply after logging in")
+	require.Equal(t, "me", string(body), "The handler's body should be returned")
+
+	status, body = adminRequest(t, "GET", srv.URL+"/__mokk/scenarios", "")
+	require.Equal(t, http.StatusOK, status, "The scenarios should be listed")
+	require.JSONEq(t, `[{"name": "session", "state": "LoggedIn"}]`, string(body), "The state should be listed")
+	status, _ = adminRequest(t, "POST", srv.URL+"/__mokk/reset", "")
+	require.Equal(t, http.StatusNoContent, status, "The state should be reset")
+	require.Equal(t, StartedState, router.Scenario("session").State(), "The scenario should be reset")
+
+	invalid, err := ParseDefinition([]byte("routes: [{regex: '^/a$', newState: Done}]"))
+	require.NoError(t, err, "The definition should be parsed")
+	_, err = invalid.Router(nil)
+	require.Error(t, err, "States without a scenario should be rejected")
+}
@@ -1,37 +1,119 @@
 package server
 
 import (
+	"context"
+	"fmt"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"regexp"
+	"strings"
 	"testing"
 )
 
 // Server is a wrapper for httptest.Server
+// TLS Servers have their own CertificateAuthority and a Client trusting it (see NewTLSServer)
+// HTTP/2 Servers have a Client using HTTP/2 (see NewHTTP2Server and NewH2CServer)
 type Server struct {
 	*httptest.Server
+	cancel    context.CancelFunc
+	ca        *CertificateAuthority
+	protocols *http.Protocols
+	client    *http.Client
 }
 
 // NewServer creates a new HTTP TestServer with the supplyed handler
 // It is immediately initialized and can be reached at Server.URL
 func NewServer(router http.Handler) *Server {
+	httpServer, cancel := newUnstartedServer(router)
+	httpServer.Start()
 	return &Server{
-		Server: httptest.NewServer(router),
+		Server: httpServer,
+		cancel: cancel,
+	}
+}
+
+// newUnstartedServer creates the httptest.Server of a Server with the supplied handler
+// and the function cancelling the contexts of its requests when the Server is closed
+// httptest.Server does not close the connections hijacked by its handlers (like WebSocketHandler's),
+// these handlers close them when the Server's context is cancelled (see serverContext)
+func newUnstartedServer(handler http.Handler) (*httptest.Server, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	base := context.WithValue(ctx, serverContextKey{}, ctx)
+	httpServer := httptest.NewUnstartedServer(handler)
+	httpServer.Config.BaseContext = func(net.Listener) context.Context {
+		return base
+	}
+	return httpServer, cancel
+}
+
+// serverContextKey is the context key of the context cancelled when the Server is closed
+type serverContextKey struct{}
+
+// serverContext returns the context cancelled when the Server serving the request is closed,
+// or a context never cancelled if the request is not served by a Server
+// Unlike the request's context, it is not cancelled when reading a hijacked connection fails
+func serverContext(req *http.Request) context.Context {
+	if ctx, ok := req.Context().Value(serverContextKey{}).(context.Context); ok {
+		return ctx
+	}
+	return context.Background()
+}
+
+// NewAdminServer creates a new HTTP Server with the supplied Router, serving its Admin API
+// under the given path prefix (see Router.WithAdmin)
+// It is immediately initialized and can be reached at Server.URL
+func NewAdminServer(router *Router, prefix string) *Server {
+	return NewServer(router.WithAdmin(prefix))
+}
+
+// Close cancels the contexts of all requests and closes all client connections,
+// so handlers waiting on their request's context return, even the ones of hijacked connections,
+// then shuts down the server and blocks until all outstanding requests have completed
+func (server *Server) Close() {
+	if server.cancel != nil {
+		server.cancel()
+	}
+	server.CloseClientConnections()
+	server.Server.Close()
+	if server.client != nil {
+		server.client.CloseIdleConnections()
 	}
 }
 
 // TestServer is a wrapper for Server created in a testing context
 type TestServer struct {
 	*Server
-	router *Router
-	test   *testing.T
+	router        *Router
+	journal       *Journal
+	test          *testing.T
+	registrations []registration
+	asserted      bool
+}
+
+// registration is a handler registered on the TestServer with its path regex and method
+type registration struct {
+	pathRegex string
+	method    string
+	handler   http.Handler
 }
 
 // NewTestServer creates a new TestServer in the given testing context and return its pointer
+// The call count expectations of its handlers are asserted automatically when the test finishes,
+// unless AssertExpectations has already been called
 func NewTestServer(t *testing.T) *TestServer {
-	return &TestServer{
-		test:   t,
-		router: NewRouter(NewTestErrorHandler(t)),
+	journal := NewJournal()
+	ts := &TestServer{
+		test:    t,
+		router:  NewRouter(NewTestErrorHandler(t)).WithJournal(journal),
+		journal: journal,
 	}
+	t.Cleanup(func() {
+		if !ts.asserted {
+			ts.AssertExpectations()
+		}
+	})
+	return ts
 }
 
 // Init inits the TestServer's underlying httptest.Server with TestHandler's Router as its handler
@@ -44,13 +126,91 @@ func (ts *TestServer) Handler() *TestHandler {
 	return NewTestHandler(NewTestErrorHandler(ts.test))
 }
 
+// WebSocketHandler returns a new WebSocketHandler initialized with the TestServer's testing context
+func (ts *TestServer) WebSocketHandler() *WebSocketHandler {
+	return NewWebSocketHandler(NewTestErrorHandler(ts.test))
+}
+
 // Handle adds a TestHandler to the TestServer's Router
+// If the path regex or the handler's options are invalid the test fails immediately
 func (ts *TestServer) Handle(pathRegex, method string, handler http.Handler) {
-	for _, route := range ts.router.routes {
-		if route.regex == pathRegex {
-			route.AddMethod(method, handler)
-			return
+	if err := ts.router.Handle(pathRegex, method, handler); err != nil {
+		ts.test.Fatalf("Cannot handle %s %s: %s", method, pathRegex, err)
+	}
+	ts.registrations = append(ts.registrations, registration{pathRegex: pathRegex, method: method, handler: handler})
+}
+
+// Scenario returns the TestServer's Scenario with the given name, creating it if it does not exist yet
+func (ts *TestServer) Scenario(name string) *Scenario {
+	return ts.router.Scenario(name)
+}
+
+// Admin enables the Admin API of the TestServer under the given path prefix (e.g. /__mokk/),
+// so the system under test can manage the TestServer's stubs when it runs in a separate process
+func (ts *TestServer) Admin(prefix string) {
+	ts.router.AddAdmin(prefix)
+}
+
+// Fallback sets the Handler of the requests which match none of the TestServer's routes
+func (ts *TestServer) Fallback(handler http.Handler) {
+	ts.router.AddFallback(handler)
+}
+
+// ProxyTo passes the requests which match none of the TestServer's routes through to the upstream URL,
+// so only a few endpoints of a real service have to be stubbed
+// If the upstream URL is invalid the test fails immediately
+func (ts *TestServer) ProxyTo(upstream string) {
+	if err := ts.router.AddProxy(upstream); err != nil {
+		ts.test.Fatalf("Cannot proxy to %s: %s", upstream, err)
+	}
+}
+
+// Requests returns every request received by the TestServer in the order they arrived
+func (ts *TestServer) Requests() []RecordedRequest {
+	return ts.journal.Requests()
+}
+
+// RequestsFor returns the requests received by the TestServer with the given method
+// whose URL path matches the given regex. An empty method matches any method
+// If the regex is invalid the test fails immediately
+func (ts *TestServer) RequestsFor(pathRegex, method string) []RecordedRequest {
+	regex, err := regexp.Compile(pathRegex)
+	if err != nil {
+		ts.test.Fatalf("Invalid path regex %q: %s", pathRegex, err)
+	}
+	return ts.journal.RequestsFor(regex, method)
+}
+
+// LastRequest returns the last request received by the TestServer, or nil if there was none
+func (ts *TestServer) LastRequest() *RecordedRequest {
+	return ts.journal.LastRequest()
+}
+
+// AssertExpectations fails the test listing every route whose handler's call count expectation was not met
+// It returns true if all expectations are met
+func (ts *TestServer) AssertExpectations() bool {
+	ts.asserted = true
+	unmet := ts.unmetExpectations()
+	if len(unmet) > 0 {
+		ts.test.Errorf("Unmet call expectations:\n%s", strings.Join(unmet, "\n"))
+		return false
+	}
+	return true
+}
+
+// unmetExpectations returns a description of every handler of the TestServer whose expectation is not met
+// in the order they were registered by Handle. Handlers shadowed by an unconditional handler
+// or removed since they were registered are checked too, so an unmet expectation is not lost with them
+func (ts *TestServer) unmetExpectations() []string {
+	var unmet []string
+	for _, registered := range ts.registrations {
+		exp, ok := registered.handler.(expecter)
+		if !ok {
+			continue
+		}
+		if err := exp.CheckExpectations(); err != nil {
+			unmet = append(unmet, fmt.Sprintf("%s %s: %s", registered.method, registered.pathRegex, err))
 		}
 	}
-	ts.router.AddRoute(NewRoute(pathRegex, NewTestErrorHandler(ts.test)).WithMethod(method, handler))
+	return unmet
 }
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Event is a Server-Sent Event streamed by a TestHandler (see TestHandler.WithEventStream)
+// Empty fields are omitted, Data with multiple lines is sent as multiple data fields
+// The TestHandler waits Delay before sending the Event
+type Event struct {
+	ID    string
+	Event string
+	Data  string
+	Retry time.Duration
+	Delay time.Duration
+}
+
+// StreamEnd tells how a TestHandler ends its streamed response
+type StreamEnd int
+
+const (
+	// CloseStream ends the response normally
+	CloseStream StreamEnd = iota
+	// DropConnection aborts the response without ending it properly, as if the connection was lost
+	DropConnection
+)
+
+// WithEventStream sets the TestHandler to stream the Events as a text/event-stream response and returns it
+// Every Event is flushed to the client as soon as it is written
+// If the request has a Last-Event-ID header matching the ID of an Event, the stream resumes after that Event
+func (handler *TestHandler) WithEventStream(events ...Event) *TestHandler {
+	handler.AddEventStream(events...)
+	return handler
+}
+
+// AddEventStream sets the TestHandler to stream the Events as a text/event-stream response
+// Every Event is flushed to the client as soon as it is written
+// If the request has a Last-Event-ID header matching the ID of an Event, the stream resumes after that Event
+func (handler *TestHandler) AddEventStream(events ...Event) {
+	handler.events = append([]Event{}, events...)
+}
+
+// WithStreamEnd sets how the TestHandler ends its streamed response and returns it
+func (handler *TestHandler) WithStreamEnd(end StreamEnd) *TestHandler {
+	handler.streamEnd = end
+	return handler
+}
+
+// AddStreamEnd sets how the TestHandler ends its streamed response
+func (handler *TestHandler) AddStreamEnd(end StreamEnd) {
+	handler.streamEnd = end
+}
+
+// streamEvents writes the response headers and status, then the Events after the request's Last-Event-ID
+// It stops as soon as the request's context is cancelled or a write fails, and returns false in this case
+func (handler *TestHandler) streamEvents(res http.ResponseWriter, req *http.Request, response Response) bool {
+	res.Header().Set("Content-Type", "text/event-stream")
+	res.Header().Set("Cache-Control", "no-cache")
+	handler.writeHead(res, response)
+	flush(res)
+	for _, event := range eventsAfter(handler.events, req.Header.Get("Last-Event-ID")) {
+		if !sleep(req.Context(), event.Delay) {
+			return false
+		}
+		if _, err := res.Write(event.encode()); err != nil {
+			return false
+		}
+		flush(res)
+	}
+	return true
+}
+
+// eventsAfter returns the Events after the one with the given ID, or all of them if none has the ID
+func eventsAfter(events []Event, lastEventID string) []Event {
+	if lastEventID == "" {
+		return events
+	}
+	for i, event := range events {
+		if event.ID == lastEventID {
+			return events[i+1:]
+		}
+	}
+	return events
+}
+
+// encode returns the Event in the text/event-stream format
+func (event Event) encode() []byte {
+	var encoded strings.Builder
+	if event.ID != "" {
+		fmt.Fprintf(&encoded, "id: %s\n", event.ID)
+	}
+	if event.Event != "" {
+		fmt.Fprintf(&encoded, "event: %s\n", event.Event)
+	}
+	if event.Retry > 0 {
+		fmt.Fprintf(&encoded, "retry: %d\n", event.Retry.Nanoseconds()/int64(time.Millisecond))
+	}
+	if event.Data != "" {
+		for _, line := range strings.Split(event.Data, "\n") {
+			fmt.Fprintf(&encoded, "data: %s\n", line)
+		}
+	}
+	encoded.WriteString("\n")
+	return []byte(encoded.String())
+}
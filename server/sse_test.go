@@ -0,0 +1,110 @@
+package server
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTestHandler_WithEventStream(t *testing.T) {
+	t.Log("Testing Server-Sent Event streams...")
+
+	srv := NewTestServer(t)
+	srv.Handle("^/events$", "GET", srv.Handler().WithEventStream(
+		Event{ID: "1", Event: "greeting", Data: "hello", Retry: 1500 * time.Millisecond},
+		Event{ID: "2", Data: "multi\nline", Delay: 10 * time.Millisecond},
+		Event{Data: "last"},
+	))
+	srv.Init()
+	defer srv.Close()
+
+	start := time.Now()
+	resp, err := http.Get(srv.URL + "/events")
+	require.NoError(t, err, "Test server shouldn't return any errors")
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err, "The stream should end properly")
+	require.True(t, time.Since(start) >= 10*time.Millisecond, "The event delays should be waited")
+	require.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"), "The content type should be set")
+	require.Equal(t, "no-cache", resp.Header.Get("Cache-Control"), "The stream should not be cached")
+	require.Equal(t,
+		"id: 1\nevent: greeting\nretry: 1500\ndata: hello\n\nid: 2\ndata: multi\ndata: line\n\ndata: last\n\n",
+		string(body),
+		"The events should be encoded")
+
+	request, err := http.NewRequest("GET", srv.URL+"/events", nil)
+	require.NoError(t, err, "Test request should be created")
+	request.Header.Set("Last-Event-ID", "2")
+	resp, err = http.DefaultClient.Do(request)
+	require.NoError(t, err, "Test server shouldn't return any errors")
+	body, err = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err, "The stream should end properly")
+	require.Equal(t, "data: last\n\n", string(body), "The stream should resume after the Last-Event-ID")
+}
+
+func TestTestHandler_WithStreamEnd(t *testing.T) {
+	t.Log("Testing event streams ending with a connection drop...")
+
+	scenario := NewScenario("stream")
+	srv := NewServer(NewRouter(nil).WithRoute(MustNewRoute("^/events$", nil).WithMethod("GET",
+		NewTestHandler(nil).
+			WithEventStream(Event{ID: "1", Data: "only"}).
+			WithStreamEnd(DropConnection).
+			WithNewState(scenario, "Dropped"))))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/events")
+	require.NoError(t, err, "The response head should be received")
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.Error(t, err, "The stream should not end properly")
+	require.Equal(t, "id: 1\ndata: only\n\n", string(body), "The events should be received before the drop")
+	require.Equal(t, "Dropped", scenario.State(), "The scenario should move to the new state before the drop")
+}
+
+func TestTestHandler_WithEventStream_cancelled(t *testing.T) {
+	t.Log("Testing event streams cancelled by the client...")
+
+	scenario := NewScenario("stream")
+	handler, handled := notifyHandled(NewRouter(nil).WithRoute(MustNewRoute("^/events$", nil).WithMethod("GET",
+		NewTestHandler(nil).
+			WithEventStream(Event{Data: "first"}, Event{Data: "late", Delay: time.Minute}).
+			WithStreamEnd(DropConnection).
+			WithNewState(scenario, "Streamed"))))
+	srv := NewServer(handler)
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	request, err := http.NewRequest("GET", srv.URL+"/events", nil)
+	require.NoError(t, err, "Test request should be created")
+	resp, err := http.DefaultClient.Do(request.WithContext(ctx))
+	require.NoError(t, err, "The response head should be received")
+	buffer := make([]byte, 64)
+	n, err := resp.Body.Read(buffer)
+	require.NoError(t, err, "The first event should be read")
+	require.Equal(t, "data: first\n\n", string(buffer[:n]), "The first event should be flushed")
+	cancel()
+	resp.Body.Close()
+	select {
+	case <-handled:
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "The handler should return when the client cancels the stream")
+	}
+	require.Equal(t, StartedState, scenario.State(), "A cancelled stream should not move the scenario")
+}
+
+// notifyHandled wraps the handler, the returned channel is closed when the first request it serves is handled
+func notifyHandled(handler http.Handler) (http.Handler, <-chan struct{}) {
+	handled := make(chan struct{})
+	var once sync.Once
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		defer once.Do(func() { close(handled) })
+		handler.ServeHTTP(res, req)
+	}), handled
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// throttleChunksPerSecond is how many chunks a throttled body is split into per second,
+// if the TestHandler has no chunk size
+const throttleChunksPerSecond = 10
+
+// WithChunkedBody sets the TestHandler to write the response body in chunks of the given size,
+// flushing each chunk and waiting the interval between them, and returns it
+// A size which is not positive or a negative interval is reported by the TestHandler (see Err)
+func (handler *TestHandler) WithChunkedBody(size int, interval time.Duration) *TestHandler {
+	handler.AddChunkedBody(size, interval)
+	return handler
+}
+
+// AddChunkedBody sets the TestHandler to write the response body in chunks of the given size,
+// flushing each chunk and waiting the interval between them
+// A size which is not positive or a negative interval is reported by the TestHandler (see Err)
+func (handler *TestHandler) AddChunkedBody(size int, interval time.Duration) {
+	if size <= 0 || interval < 0 {
+		handler.invalidOption(errors.Errorf("Invalid body chunking: size %d interval %s", size, interval))
+		return
+	}
+	handler.chunkSize, handler.chunkInterval = size, interval
+}
+
+// WithThrottle sets the TestHandler to write the response body at the given rate in bytes per second,
+// flushing each chunk, and returns it
+// Without a chunk size (see WithChunkedBody) the body is written in chunks of a tenth of the rate
+// A rate which is not positive is reported by the TestHandler (see Err)
+func (handler *TestHandler) WithThrottle(bytesPerSecond int) *TestHandler {
+	handler.AddThrottle(bytesPerSecond)
+	return handler
+}
+
+// AddThrottle sets the TestHandler to write the response body at the given rate in bytes per second,
+// flushing each chunk
+// Without a chunk size (see AddChunkedBody) the body is written in chunks of a tenth of the rate
+// A rate which is not positive is reported by the TestHandler (see Err)
+func (handler *TestHandler) AddThrottle(bytesPerSecond int) {
+	if bytesPerSecond <= 0 {
+		handler.invalidOption(errors.Errorf("Invalid throttle: %d bytes per second", bytesPerSecond))
+		return
+	}
+	handler.bytesPerSecond = bytesPerSecond
+}
+
+// streamed returns true if the TestHandler writes its response body in chunks
+func (handler *TestHandler) streamed() bool {
+	return handler.chunkSize > 0 || handler.bytesPerSecond > 0
+}
+
+// writeChunks writes the body chunk by chunk, flushing each one
+// A throttled body waits until the rate allows the next chunk, measured from the first one, so waits do not drift
+// It stops as soon as the request's context is cancelled, like a slow reader timing out,
+// and returns the context's error in this case
+func (handler *TestHandler) writeChunks(res http.ResponseWriter, req *http.Request, body []byte) error {
+	size := handler.chunkSize
+	if size == 0 {
+		size = handler.bytesPerSecond / throttleChunksPerSecond
+		if size == 0 {
+			size = 1
+		}
+	}
+	start := time.Now()
+	for written := 0; written < len(body); {
+		if written > 0 && !sleep(req.Context(), handler.chunkWait(start, written)) {
+			return req.Context().Err()
+		}
+		end := written + size
+		if end > len(body) {
+			end = len(body)
+		}
+		if _, err := res.Write(body[written:end]); err != nil {
+			if ctxErr := req.Context().Err(); ctxErr != nil {
+				return ctxErr
+			}
+			return err
+		}
+		flush(res)
+		written = end
+	}
+	return nil
+}
+
+// chunkWait returns how long to wait before the next chunk, after the given number of bytes written
+func (handler *TestHandler) chunkWait(start time.Time, written int) time.Duration {
+	wait := handler.chunkInterval
+	if handler.bytesPerSecond > 0 {
+		due := time.Duration(int64(written) * int64(time.Second) / int64(handler.bytesPerSecond))
+		if throttle := due - time.Since(start); throttle > wait {
+			wait = throttle
+		}
+	}
+	return wait
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTestHandler_WithChunkedBody(t *testing.T) {
+	t.Log("Testing chunked response bodies...")
+
+	srv := NewTestServer(t)
+	srv.Handle("^/download$", "GET", srv.Handler().
+		WithResponseBody([]byte("abcdefgh")).
+		WithChunkedBody(4, time.Second))
+	srv.Init()
+	defer srv.Close()
+
+	start := time.Now()
+	resp, err := http.Get(srv.URL + "/download")
+	require.NoError(t, err, "Test server shouldn't return any errors")
+	defer resp.Body.Close()
+	headers := time.Now()
+	require.Equal(t, []string{"chunked"}, resp.TransferEncoding, "The body should be streamed")
+
+	buffer := make([]byte, 8)
+	n, err := resp.Body.Read(buffer)
+	require.NoError(t, err, "The first chunk should be read")
+	require.Equal(t, "abcd", string(buffer[:n]), "The first chunk should be flushed alone")
+	// The interval is far longer than the margin, so the first chunk is not waited for even on a loaded machine
+	require.True(t, time.Since(headers) < 500*time.Millisecond, "The first chunk should arrive with the headers")
+
+	rest, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err, "The rest of the body should be read")
+	require.Equal(t, "efgh", string(rest), "The rest of the body should arrive")
+	require.True(t, time.Since(start) >= time.Second, "The interval should be waited between the chunks")
+}
+
+func TestTestHandler_WithThrottle(t *testing.T) {
+	t.Log("Testing throttled response bodies...")
+
+	body := bytes.Repeat([]byte("x"), 3000)
+	srv := NewTestServer(t)
+	srv.Handle("^/slow$", "GET", srv.Handler().WithResponseBody(body).WithThrottle(10000))
+	srv.Init()
+	defer srv.Close()
+
+	start := time.Now()
+	resp, err := http.Get(srv.URL + "/slow")
+	require.NoError(t, err, "Test server shouldn't return any errors")
+	defer resp.Body.Close()
+	received, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err, "The body should be read")
+	require.Equal(t, body, received, "The whole body should arrive")
+	require.True(t, time.Since(start) >= 200*time.Millisecond, "The body should be throttled to 10000 bytes per second")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	req, err := http.NewRequest("GET", srv.URL+"/slow", nil)
+	require.NoError(t, err, "Test request should be created")
+	resp, err = http.DefaultClient.Do(req.WithContext(ctx))
+	require.NoError(t, err, "The headers should arrive before the timeout")
+	defer resp.Body.Close()
+	_, err = io.Copy(ioutil.Discard, resp.Body)
+	require.Error(t, err, "A slow body should time out the reader")
+}
+
+func TestTestHandler_WithChunkedBody_cancelled(t *testing.T) {
+	t.Log("Testing chunked response bodies cancelled by the client...")
+
+	scenario := NewScenario("download")
+	handler, handled := notifyHandled(NewRouter(nil).WithRoute(MustNewRoute("^/download$", nil).WithMethod("GET",
+		NewTestHandler(nil).
+			WithResponseBody([]byte("abcdefgh")).
+			WithChunkedBody(4, time.Minute).
+			WithNewState(scenario, "Downloaded"))))
+	srv := NewServer(handler)
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequest("GET", srv.URL+"/download", nil)
+	require.NoError(t, err, "Test request should be created")
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	require.NoError(t, err, "The headers should be received")
+	buffer := make([]byte, 8)
+	n, err := resp.Body.Read(buffer)
+	require.NoError(t, err, "The first chunk should be read")
+	require.Equal(t, "abcd", string(buffer[:n]), "The first chunk should be flushed alone")
+	cancel()
+	resp.Body.Close()
+	select {
+	case <-handled:
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "The handler should return when the client cancels the body")
+	}
+	require.Equal(t, StartedState, scenario.State(), "A cancelled body should not move the scenario")
+}
+
+func TestTestHandler_WithChunkedBody_invalid(t *testing.T) {
+	t.Log("Testing invalid chunking and throttle options...")
+
+	handler := NewTestHandler(nil).WithChunkedBody(0, time.Millisecond).WithThrottle(-1)
+	require.EqualError(t, handler.Err(),
+		"Invalid TestHandler options:\nInvalid body chunking: size 0 interval 1ms\nInvalid throttle: -1 bytes per second",
+		"Invalid chunking and throttle options should be reported")
+	require.False(t, handler.streamed(), "Invalid options should not be set")
+	require.Error(t, NewRouter(nil).Handle("^/download$", "GET", handler), "The handler should not be registered")
+}
@@ -0,0 +1,204 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// TemplateData is the data available in the TestHandler's response templates
+// JSON is the decoded request body, or nil if the body is not valid JSON
+type TemplateData struct {
+	Method     string
+	URL        *url.URL
+	Path       string
+	PathParams map[string]string
+	Query      url.Values
+	Header     http.Header
+	Body       string
+	JSON       interface{}
+	Call       int
+}
+
+// headerTemplate is a templated response header
+type headerTemplate struct {
+	key      string
+	template *template.Template
+}
+
+// templateState holds the state of the template helpers of a TestHandler
+type templateState struct {
+	random  *rand.Rand
+	counter int
+	mutex   sync.Mutex
+}
+
+// WithResponseTemplate sets a text/template response body on the TestHandler and returns it
+// The template is executed with TemplateData on every call, and can use the helpers:
+// uuid, now, counter and random (see WithTemplateSeed)
+// It panics if the template is invalid
+func (handler *TestHandler) WithResponseTemplate(body string) *TestHandler {
+	handler.AddResponseTemplate(body)
+	return handler
+}
+
+// AddResponseTemplate sets a text/template response body on the TestHandler
+// The template is executed with TemplateData on every call, and can use the helpers:
+// uuid, now, counter and random (see WithTemplateSeed)
+// It panics if the template is invalid
+func (handler *TestHandler) AddResponseTemplate(body string) {
+	handler.bodyTemplate = handler.parseTemplate("body", body)
+}
+
+// WithResponseHeaderTemplate adds a text/template response header to the TestHandler and returns it
+// It panics if the template is invalid
+func (handler *TestHandler) WithResponseHeaderTemplate(key string, value string) *TestHandler {
+	handler.AddResponseHeaderTemplate(key, value)
+	return handler
+}
+
+// AddResponseHeaderTemplate adds a text/template response header to the TestHandler
+// It panics if the template is invalid
+func (handler *TestHandler) AddResponseHeaderTemplate(key string, value string) {
+	handler.headerTemplates = append(handler.headerTemplates, headerTemplate{
+		key:      key,
+		template: handler.parseTemplate(key, value),
+	})
+}
+
+// WithTemplateSeed seeds the random source of the uuid and random template helpers and returns the TestHandler
+// The same seed produces the same values, without a seed they are different on every run
+func (handler *TestHandler) WithTemplateSeed(seed int64) *TestHandler {
+	handler.AddTemplateSeed(seed)
+	return handler
+}
+
+// AddTemplateSeed seeds the random source of the uuid and random template helpers
+// The same seed produces the same values, without a seed they are different on every run
+func (handler *TestHandler) AddTemplateSeed(seed int64) {
+	handler.templateState.mutex.Lock()
+	defer handler.templateState.mutex.Unlock()
+	handler.templateState.random = rand.New(rand.NewSource(seed))
+}
+
+// parseTemplate parses a response template with the TestHandler's helpers, it panics if the template is invalid
+func (handler *TestHandler) parseTemplate(name string, text string) *template.Template {
+	return template.Must(handler.newTemplate(name, text))
+}
+
+// newTemplate parses a response template with the TestHandler's helpers
+func (handler *TestHandler) newTemplate(name string, text string) (*template.Template, error) {
+	return template.New(name).Funcs(handler.templateFuncs()).Parse(text)
+}
+
+// templateFuncs returns the template helpers bound to the TestHandler's template state
+func (handler *TestHandler) templateFuncs() template.FuncMap {
+	state := &handler.templateState
+	return template.FuncMap{
+		"uuid": state.uuid,
+		"now":  time.Now,
+		"counter": func() int {
+			state.mutex.Lock()
+			defer state.mutex.Unlock()
+			state.counter++
+			return state.counter
+		},
+		"random": func(min, max int) int {
+			state.mutex.Lock()
+			defer state.mutex.Unlock()
+			if max <= min {
+				return min
+			}
+			return min + state.source().Intn(max-min+1)
+		},
+	}
+}
+
+// uuid returns a random version 4 UUID
+func (state *templateState) uuid() string {
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+	id := make([]byte, 16)
+	state.source().Read(id)
+	id[6] = (id[6] & 0x0f) | 0x40
+	id[8] = (id[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", id[0:4], id[4:6], id[6:8], id[8:10], id[10:])
+}
+
+// source returns the random source, seeding it with the current time if it has no seed yet
+// The caller must hold the mutex
+func (state *templateState) source() *rand.Rand {
+	if state.random == nil {
+		state.random = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	return state.random
+}
+
+// renderTemplates executes the TestHandler's response templates and returns the response with their result
+func (handler *TestHandler) renderTemplates(req *http.Request, response Response, call int) (Response, error) {
+	if handler.bodyTemplate == nil && len(handler.headerTemplates) == 0 {
+		return response, nil
+	}
+	data, err := newTemplateData(req, call)
+	if err != nil {
+		return response, err
+	}
+	if handler.bodyTemplate != nil {
+		if response.Body, err = executeTemplate(handler.bodyTemplate, data); err != nil {
+			return response, err
+		}
+	}
+	headers := make(http.Header)
+	for key, values := range response.Headers {
+		headers[key] = append([]string(nil), values...)
+	}
+	for _, header := range handler.headerTemplates {
+		value, err := executeTemplate(header.template, data)
+		if err != nil {
+			return response, err
+		}
+		headers.Add(header.key, string(value))
+	}
+	response.Headers = headers
+	return response, nil
+}
+
+// newTemplateData collects the TemplateData from the request
+func newTemplateData(req *http.Request, call int) (*TemplateData, error) {
+	body, err := readBody(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "Cannot read request body")
+	}
+	var document interface{}
+	if json.Unmarshal(body, &document) != nil {
+		document = nil
+	}
+	return &TemplateData{
+		Method:     req.Method,
+		URL:        req.URL,
+		Path:       req.URL.Path,
+		PathParams: PathParams(req),
+		Query:      req.URL.Query(),
+		Header:     req.Header,
+		Body:       string(body),
+		JSON:       document,
+		Call:       call,
+	}, nil
+}
+
+// executeTemplate executes the template with the given data
+func executeTemplate(tmpl *template.Template, data *TemplateData) ([]byte, error) {
+	var buffer bytes.Buffer
+	if err := tmpl.Execute(&buffer, data); err != nil {
+		return nil, errors.Wrapf(err, "Cannot execute response template %q", tmpl.Name())
+	}
+	return buffer.Bytes(), nil
+}
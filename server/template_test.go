@@ -0,0 +1,67 @@
+package server
+
+import (
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestResponseTemplate(t *testing.T) {
+	t.Log("Testing response templates...")
+
+	srv := NewTestServer(t)
+	srv.Handle(`^/users/(?P<id>\d+)$`, "POST", srv.Handler().
+		WithResponseStatus(http.StatusCreated).
+		WithResponseHeaderTemplate("Location", "/users/{{.PathParams.id}}").
+		WithResponseTemplate(
+			`{"id": {{.PathParams.id}}, "name": "{{.JSON.name}}", "page": "{{.Query.Get "page"}}", `+
+				`"agent": "{{.Header.Get "X-Agent"}}", "method": "{{.Method}}", "call": {{.Call}}, `+
+				`"counter": {{counter}}, "request": "{{uuid}}", "year": {{now.Year}}}`))
+	srv.Init()
+	defer srv.Close()
+
+	request, err := http.NewRequest("POST", srv.URL+"/users/42?page=2", strings.NewReader(`{"name": "alice"}`))
+	require.NoError(t, err, "Test request should be created")
+	request.Header.Set("X-Agent", "test")
+	resp, err := http.DefaultClient.Do(request)
+	require.NoError(t, err, "Test server shouldn't return any errors")
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusCreated, resp.StatusCode, "Response should be HTTP 201 Created")
+	require.Equal(t, "/users/42", resp.Header.Get("Location"), "The header template should be rendered")
+
+	respBody, readErr := ioutil.ReadAll(resp.Body)
+	require.NoError(t, readErr, "The response body should be readable")
+	require.Regexp(t,
+		regexp.MustCompile(`^\{"id": 42, "name": "alice", "page": "2", "agent": "test", "method": "POST", `+
+			`"call": 1, "counter": 1, "request": "[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}", `+
+			`"year": \d{4}\}$`),
+		string(respBody),
+		"The body template should be rendered")
+}
+
+func TestResponseTemplate_seed(t *testing.T) {
+	t.Log("Testing seeded response template helpers...")
+
+	render := func() string {
+		handler := NewTestHandler(nil).WithTemplateSeed(42).WithResponseTemplate("{{uuid}} {{random 1 100}}")
+		data := &TemplateData{}
+		body, err := executeTemplate(handler.bodyTemplate, data)
+		require.NoError(t, err, "The template should be executed")
+		return string(body)
+	}
+	require.Equal(t, render(), render(), "The same seed should produce the same values")
+
+	require.Panics(t, func() { NewTestHandler(nil).WithResponseTemplate("{{") }, "Invalid templates should panic")
+
+	handler := NewTestHandler(nil).WithResponseTemplate("{{.Missing}}")
+	srv := NewServer(handler)
+	defer srv.Close()
+	resp, err := http.Get(srv.URL)
+	require.NoError(t, err, "Test server shouldn't return any errors")
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusInternalServerError, resp.StatusCode, "Failing templates should be reported")
+}
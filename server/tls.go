@@ -0,0 +1,234 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// certificateValidity is the validity period of the ephemeral certificates
+const certificateValidity = 24 * time.Hour
+
+// DefaultClientCommonName is the subject common name of the client certificate of mutual TLS Servers' Client
+const DefaultClientCommonName = "mokk-client"
+
+// CertificateAuthority is an ephemeral CA issuing the certificates of TLS Servers and their clients
+type CertificateAuthority struct {
+	certificate *x509.Certificate
+	key         *ecdsa.PrivateKey
+	pool        *x509.CertPool
+}
+
+// NewCertificateAuthority creates a new self signed CertificateAuthority and returns its pointer
+func NewCertificateAuthority() (*CertificateAuthority, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, errors.Wrap(err, "Cannot generate CA key")
+	}
+	template, err := certificateTemplate(pkix.Name{CommonName: "mokk ephemeral CA"})
+	if err != nil {
+		return nil, err
+	}
+	template.IsCA = true
+	template.BasicConstraintsValid = true
+	template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, errors.Wrap(err, "Cannot create CA certificate")
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, errors.Wrap(err, "Cannot parse CA certificate")
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(certificate)
+	return &CertificateAuthority{certificate: certificate, key: key, pool: pool}, nil
+}
+
+// Certificate returns the certificate of the CertificateAuthority
+func (ca *CertificateAuthority) Certificate() *x509.Certificate {
+	return ca.certificate
+}
+
+// CertificatePEM returns the PEM encoded certificate of the CertificateAuthority
+func (ca *CertificateAuthority) CertificatePEM() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.certificate.Raw})
+}
+
+// Pool returns a CertPool containing only the CertificateAuthority's certificate
+func (ca *CertificateAuthority) Pool() *x509.CertPool {
+	return ca.pool
+}
+
+// IssueServerCertificate issues a server certificate for the given host names and IP addresses
+func (ca *CertificateAuthority) IssueServerCertificate(hosts ...string) (tls.Certificate, error) {
+	template, err := certificateTemplate(pkix.Name{CommonName: "mokk server"})
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+	return ca.issue(template)
+}
+
+// IssueClientCertificate issues a client certificate with the given subject
+func (ca *CertificateAuthority) IssueClientCertificate(subject pkix.Name) (tls.Certificate, error) {
+	template, err := certificateTemplate(subject)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	return ca.issue(template)
+}
+
+// issue signs the certificate template with a new key and returns it as a tls.Certificate
+func (ca *CertificateAuthority) issue(template *x509.Certificate) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, errors.Wrap(err, "Cannot generate certificate key")
+	}
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.certificate, &key.PublicKey, ca.key)
+	if err != nil {
+		return tls.Certificate{}, errors.Wrap(err, "Cannot create certificate")
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return tls.Certificate{}, errors.Wrap(err, "Cannot parse certificate")
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, nil
+}
+
+// certificateTemplate returns a certificate template with the given subject,
+// a random serial number and the ephemeral validity period
+func certificateTemplate(subject pkix.Name) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, errors.Wrap(err, "Cannot generate serial number")
+	}
+	now := time.Now()
+	return &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      subject,
+		NotBefore:    now.Add(-time.Minute),
+		NotAfter:     now.Add(certificateValidity),
+	}, nil
+}
+
+// NewTLSServer creates a new HTTPS Server with the supplied handler
+// Its certificate is issued by a new ephemeral CertificateAuthority, which is trusted by its Client
+// It is immediately initialized and can be reached at Server.URL
+// It panics if the certificates cannot be created
+func NewTLSServer(handler http.Handler) *Server {
+	return newTLSServer(handler, tlsOptions{})
+}
+
+// NewMutualTLSServer is like NewTLSServer, but the Server requires client certificates issued by its CA
+// Its Client presents a certificate with the DefaultClientCommonName, use NewClient for other identities
+func NewMutualTLSServer(handler http.Handler) *Server {
+	return newTLSServer(handler, tlsOptions{mutual: true})
+}
+
+// tlsOptions are the options of a TLS Server
+// mutual requires client certificates, http2 enables HTTP/2 besides HTTP/1.1
+type tlsOptions struct {
+	mutual bool
+	http2  bool
+}
+
+// newTLSServer creates and starts the HTTPS Server with the given options
+func newTLSServer(handler http.Handler, options tlsOptions) *Server {
+	ca, err := NewCertificateAuthority()
+	if err != nil {
+		panic(err)
+	}
+	serverCertificate, err := ca.IssueServerCertificate("localhost", "127.0.0.1", "::1")
+	if err != nil {
+		panic(err)
+	}
+	httpServer, cancel := newUnstartedServer(handler)
+	httpServer.TLS = &tls.Config{Certificates: []tls.Certificate{serverCertificate}}
+	httpServer.EnableHTTP2 = options.http2
+	var clientCertificates []tls.Certificate
+	if options.mutual {
+		httpServer.TLS.ClientAuth = tls.RequireAndVerifyClientCert
+		httpServer.TLS.ClientCAs = ca.Pool()
+		clientCertificate, err := ca.IssueClientCertificate(pkix.Name{CommonName: DefaultClientCommonName})
+		if err != nil {
+			panic(err)
+		}
+		clientCertificates = append(clientCertificates, clientCertificate)
+	}
+	httpServer.StartTLS()
+	server := &Server{Server: httpServer, cancel: cancel, ca: ca}
+	if options.http2 {
+		server.protocols = new(http.Protocols)
+		server.protocols.SetHTTP1(true)
+		server.protocols.SetHTTP2(true)
+	}
+	server.client = server.NewClient(clientCertificates...)
+	return server
+}
+
+// CA returns the CertificateAuthority of a TLS Server, or nil if the Server does not use TLS
+func (server *Server) CA() *CertificateAuthority {
+	return server.ca
+}
+
+// CertPool returns the CertPool which verifies the certificate of a TLS Server, or nil if it does not use TLS
+func (server *Server) CertPool() *x509.CertPool {
+	if server.ca == nil {
+		return nil
+	}
+	return server.ca.Pool()
+}
+
+// Client returns an HTTP client configured for making requests to the Server
+// The client of a TLS Server trusts its CA, and presents a client certificate if the Server requires one
+func (server *Server) Client() *http.Client {
+	if server.client != nil {
+		return server.client
+	}
+	return server.Server.Client()
+}
+
+// NewClient returns a new HTTP client trusting the TLS Server's CA and presenting the given client certificates
+// The client uses the same protocols as the Server, e.g. HTTP/2 (see NewHTTP2Server and NewH2CServer)
+func (server *Server) NewClient(certificates ...tls.Certificate) *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				RootCAs:      server.CertPool(),
+				Certificates: certificates,
+			},
+			Protocols: server.protocols,
+		},
+	}
+}
+
+// InitTLS inits the TestServer's underlying Server as an HTTPS server (see NewTLSServer)
+func (ts *TestServer) InitTLS() {
+	ts.Server = NewTLSServer(ts.router)
+}
+
+// InitMutualTLS inits the TestServer's underlying Server as an HTTPS server
+// requiring client certificates (see NewMutualTLSServer)
+func (ts *TestServer) InitMutualTLS() {
+	ts.Server = NewMutualTLSServer(ts.router)
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewTLSServer(t *testing.T) {
+	t.Log("Testing TLS servers...")
+
+	srv := NewTLSServer(NewRouter(nil).WithRoute(MustNewRoute("^/secure$", nil).WithMethod("GET", NewTestHandler(nil))))
+	defer srv.Close()
+	require.True(t, strings.HasPrefix(srv.URL, "https://"), "The server should use HTTPS")
+
+	resp, err := srv.Client().Get(srv.URL + "/secure")
+	require.NoError(t, err, "The server's client should trust its certificate")
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode, "Response should be HTTP 200 OK")
+	require.Equal(t, "mokk server", resp.TLS.PeerCertificates[0].Subject.CommonName,
+		"The issued certificate should be served")
+
+	_, err = http.Get(srv.URL + "/secure")
+	require.Error(t, err, "Clients not trusting the CA should fail")
+
+	block, _ := pem.Decode(srv.CA().CertificatePEM())
+	require.NotNil(t, block, "The CA certificate should be PEM encoded")
+	ca, err := x509.ParseCertificate(block.Bytes)
+	require.NoError(t, err, "The CA certificate should be parsed")
+	require.True(t, ca.IsCA, "The CA certificate should be a CA")
+	require.NotNil(t, srv.CertPool(), "The CA pool should be exposed")
+
+	plain := NewServer(http.NotFoundHandler())
+	defer plain.Close()
+	require.Nil(t, plain.CA(), "Plain servers should have no CA")
+	require.Nil(t, plain.CertPool(), "Plain servers should have no CA pool")
+	require.NotNil(t, plain.Client(), "Plain servers should have a client")
+}
+
+func TestNewMutualTLSServer(t *testing.T) {
+	t.Log("Testing mutual TLS servers...")
+
+	srv := NewMutualTLSServer(NewRouter(nil).WithRoute(MustNewRoute("^/secure$", nil).WithMethod("GET",
+		NewTestHandler(nil).WithMatcher(MatchClientCertCommonName(DefaultClientCommonName)))))
+	defer srv.Close()
+
+	resp, err := srv.Client().Get(srv.URL + "/secure")
+	require.NoError(t, err, "The server's client should present a client certificate")
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode, "The client certificate's subject should match")
+
+	_, err = srv.NewClient().Get(srv.URL + "/secure")
+	require.Error(t, err, "Clients without certificates should be rejected")
+
+	certificate, err := srv.CA().IssueClientCertificate(pkix.Name{CommonName: "intruder"})
+	require.NoError(t, err, "The client certificate should be issued")
+	resp, err = srv.NewClient(certificate).Get(srv.URL + "/secure")
+	require.NoError(t, err, "Clients with certificates issued by the CA should be accepted")
+	resp.Body.Close()
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode, "The client certificate's subject should not match")
+}
+
+func TestTestServer_InitMutualTLS(t *testing.T) {
+	t.Log("Testing mutual TLS test servers...")
+
+	srv := NewTestServer(t)
+	srv.Handle("^/secure$", "GET", srv.Handler().WithMatcher(MustMatchClientCertSubject("^CN=mokk-client$")))
+	srv.InitMutualTLS()
+	defer srv.Close()
+
+	resp, err := srv.Client().Get(srv.URL + "/secure")
+	require.NoError(t, err, "The server's client should present a client certificate")
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode, "The client certificate's subject should match")
+
+	tlsSrv := NewTestServer(t)
+	tlsSrv.Handle("^/secure$", "GET", tlsSrv.Handler())
+	tlsSrv.InitTLS()
+	defer tlsSrv.Close()
+	resp, err = tlsSrv.Client().Get(tlsSrv.URL + "/secure")
+	require.NoError(t, err, "The server's client should trust its certificate")
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode, "Response should be HTTP 200 OK")
+}
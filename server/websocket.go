@@ -0,0 +1,365 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// webSocketCloseTimeout is how long the WebSocketHandler waits for the client to answer its close frame
+const webSocketCloseTimeout = time.Second
+
+// webSocketReadTimeout is how long an expectation of the script waits for its message,
+// unless it has its own read timeout (see WebSocketHandler.WithReadTimeout)
+const webSocketReadTimeout = 5 * time.Second
+
+// WebSocketHandler is a Handler which upgrades the request to a WebSocket connection,
+// then follows its script of expected inbound messages and outbound messages in order
+// If an inbound message does not match its expectation, or does not arrive within its read timeout,
+// the connection is closed with 1008 (policy violation)
+// and the WebSocketHandler's ErrorHandler is called with HTTP 400 Bad Request
+// Frames breaking the protocol close it with 1002 (protocol error), invalid UTF-8 text with 1007 (invalid payload)
+// (after the upgrade nothing can be written on the ResponseWriter, it is discarded)
+// When the script is finished the connection is closed normally, unless the WebSocketHandler keeps it open
+// The connection is closed without calling the ErrorHandler when the Server is closed
+type WebSocketHandler struct {
+	steps       []webSocketStep
+	subprotocol string
+	keepOpen    bool
+
+	calls int
+	mutex sync.Mutex
+
+	invalidOptions []string
+
+	errorHandler ErrorHandler
+}
+
+// webSocketStep is a step of the WebSocketHandler's script
+// It either expects an inbound message within its timeout, sends a message or pauses
+type webSocketStep struct {
+	expect  func(message wsMessage) error
+	timeout time.Duration
+	send    *wsMessage
+	pause   time.Duration
+}
+
+// NewWebSocketHandler creates a new WebSocketHandler with the given ErrorHandler
+// if no ErrorHandler supplied it will fall back to the BasicErrorHandler
+func NewWebSocketHandler(errHandler ErrorHandler) *WebSocketHandler {
+	var handler ErrorHandler = &BasicErrorHandler{}
+	if errHandler != nil {
+		handler = errHandler
+	}
+	return &WebSocketHandler{
+		errorHandler: handler,
+	}
+}
+
+// Err returns an error listing every invalid option set on the WebSocketHandler, or nil if all of them are valid
+// Options are validated when they are set, but reported only here and on every request the WebSocketHandler serves,
+// so the builder methods can be chained
+func (handler *WebSocketHandler) Err() error {
+	if len(handler.invalidOptions) == 0 {
+		return nil
+	}
+	return errors.Errorf("Invalid WebSocketHandler options:\n%s", strings.Join(handler.invalidOptions, "\n"))
+}
+
+// invalidOption records the error of an invalid option, see Err
+func (handler *WebSocketHandler) invalidOption(err error) {
+	handler.invalidOptions = append(handler.invalidOptions, err.Error())
+}
+
+// WithExpectMessage adds a step expecting a message equal to the given one to the script and returns the handler
+func (handler *WebSocketHandler) WithExpectMessage(message string) *WebSocketHandler {
+	handler.AddExpectMessage(message)
+	return handler
+}
+
+// AddExpectMessage adds a step expecting a message equal to the given one to the script
+func (handler *WebSocketHandler) AddExpectMessage(message string) {
+	handler.addExpect(func(actual wsMessage) error {
+		if string(actual.payload) != message {
+			return errors.Errorf("expected message %q got %q", message, actual.payload)
+		}
+		return nil
+	})
+}
+
+// WithExpectMessageRegex adds a step expecting a message matching the regex to the script and returns the handler
+// An invalid regex is reported by the WebSocketHandler (see Err)
+func (handler *WebSocketHandler) WithExpectMessageRegex(regex string) *WebSocketHandler {
+	handler.AddExpectMessageRegex(regex)
+	return handler
+}
+
+// AddExpectMessageRegex adds a step expecting a message matching the regex to the script
+// An invalid regex is reported by the WebSocketHandler (see Err)
+func (handler *WebSocketHandler) AddExpectMessageRegex(regex string) {
+	compiled, err := regexp.Compile(regex)
+	if err != nil {
+		handler.invalidOption(errors.Wrapf(err, "Invalid message regex %q", regex))
+		return
+	}
+	handler.addExpect(func(actual wsMessage) error {
+		if !compiled.Match(actual.payload) {
+			return errors.Errorf("expected message to match %q got %q", regex, actual.payload)
+		}
+		return nil
+	})
+}
+
+// WithExpectJSON adds a step expecting a JSON message semantically equal to the given one to the script
+// and returns the handler. It panics if the given JSON is invalid
+func (handler *WebSocketHandler) WithExpectJSON(message []byte) *WebSocketHandler {
+	handler.AddExpectJSON(message)
+	return handler
+}
+
+// AddExpectJSON adds a step expecting a JSON message semantically equal to the given one to the script
+// It panics if the given JSON is invalid
+func (handler *WebSocketHandler) AddExpectJSON(message []byte) {
+	handler.addExpectJSON(message, false)
+}
+
+// WithExpectJSONSubset adds a step expecting a JSON message containing every field of the given one to the script
+// and returns the handler. It panics if the given JSON is invalid
+func (handler *WebSocketHandler) WithExpectJSONSubset(message []byte) *WebSocketHandler {
+	handler.AddExpectJSONSubset(message)
+	return handler
+}
+
+// AddExpectJSONSubset adds a step expecting a JSON message containing every field of the given one to the script
+// It panics if the given JSON is invalid
+func (handler *WebSocketHandler) AddExpectJSONSubset(message []byte) {
+	handler.addExpectJSON(message, true)
+}
+
+// addExpectJSON adds a step expecting a JSON message to the script
+func (handler *WebSocketHandler) addExpectJSON(message []byte, subset bool) {
+	requirement := newJSONRequirement(message, subset)
+	handler.addExpect(func(actual wsMessage) error {
+		value, err := decodeJSON(actual.payload)
+		if err != nil {
+			return errors.Wrapf(err, "expected JSON message got %q", actual.payload)
+		}
+		if diff := jsonDiff("$", requirement.expected, value, subset); len(diff) > 0 {
+			return errors.Errorf("JSON message does not match:\n%s", strings.Join(diff, "\n"))
+		}
+		return nil
+	})
+}
+
+// addExpect adds a step expecting a message checked by the given function to the script
+func (handler *WebSocketHandler) addExpect(expect func(message wsMessage) error) {
+	handler.steps = append(handler.steps, webSocketStep{expect: expect})
+}
+
+// WithReadTimeout sets how long the last expectation of the script waits for its message and returns the handler
+// A timeout which is not positive, or without an expectation as the last step of the script,
+// is reported by the WebSocketHandler (see Err)
+func (handler *WebSocketHandler) WithReadTimeout(timeout time.Duration) *WebSocketHandler {
+	handler.AddReadTimeout(timeout)
+	return handler
+}
+
+// AddReadTimeout sets how long the last expectation of the script waits for its message
+// A timeout which is not positive, or without an expectation as the last step of the script,
+// is reported by the WebSocketHandler (see Err)
+func (handler *WebSocketHandler) AddReadTimeout(timeout time.Duration) {
+	last := len(handler.steps) - 1
+	switch {
+	case timeout <= 0:
+		handler.invalidOption(errors.Errorf("Invalid read timeout %s", timeout))
+	case last < 0 || handler.steps[last].expect == nil:
+		handler.invalidOption(errors.New("Read timeout without an expected message"))
+	default:
+		handler.steps[last].timeout = timeout
+	}
+}
+
+// WithSend adds a step sending the given text message to the script and returns the handler
+func (handler *WebSocketHandler) WithSend(message string) *WebSocketHandler {
+	handler.AddSend(message)
+	return handler
+}
+
+// AddSend adds a step sending the given text message to the script
+func (handler *WebSocketHandler) AddSend(message string) {
+	handler.steps = append(handler.steps, webSocketStep{send: &wsMessage{opcode: wsOpText, payload: []byte(message)}})
+}
+
+// WithSendBinary adds a step sending the given binary message to the script and returns the handler
+func (handler *WebSocketHandler) WithSendBinary(message []byte) *WebSocketHandler {
+	handler.AddSendBinary(message)
+	return handler
+}
+
+// AddSendBinary adds a step sending the given binary message to the script
+func (handler *WebSocketHandler) AddSendBinary(message []byte) {
+	handler.steps = append(handler.steps, webSocketStep{send: &wsMessage{opcode: wsOpBinary, payload: message}})
+}
+
+// WithPause adds a step waiting the given duration to the script, to push messages later, and returns the handler
+func (handler *WebSocketHandler) WithPause(duration time.Duration) *WebSocketHandler {
+	handler.AddPause(duration)
+	return handler
+}
+
+// AddPause adds a step waiting the given duration to the script, to push messages later
+func (handler *WebSocketHandler) AddPause(duration time.Duration) {
+	handler.steps = append(handler.steps, webSocketStep{pause: duration})
+}
+
+// WithSubprotocol sets the subprotocol selected during the handshake, if the client offers it, and returns the handler
+func (handler *WebSocketHandler) WithSubprotocol(subprotocol string) *WebSocketHandler {
+	handler.AddSubprotocol(subprotocol)
+	return handler
+}
+
+// AddSubprotocol sets the subprotocol selected during the handshake, if the client offers it
+func (handler *WebSocketHandler) AddSubprotocol(subprotocol string) {
+	handler.subprotocol = subprotocol
+}
+
+// WithKeepOpen sets whether the connection is kept open after the script, until the client closes it,
+// and returns the handler. Any message received after the script is unexpected
+func (handler *WebSocketHandler) WithKeepOpen(keepOpen bool) *WebSocketHandler {
+	handler.AddKeepOpen(keepOpen)
+	return handler
+}
+
+// AddKeepOpen sets whether the connection is kept open after the script, until the client closes it
+// Any message received after the script is unexpected
+func (handler *WebSocketHandler) AddKeepOpen(keepOpen bool) {
+	handler.keepOpen = keepOpen
+}
+
+// Calls returns the number of connections the WebSocketHandler has received so far
+func (handler *WebSocketHandler) Calls() int {
+	handler.mutex.Lock()
+	defer handler.mutex.Unlock()
+	return handler.calls
+}
+
+// ServeHTTP
+// The WebSocketHandler will count the call, call the ErrorHandler with HTTP 500 Internal Server Error
+// if it has invalid options (see Err), then upgrade the connection,
+// calling the ErrorHandler with HTTP 400 Bad Request if the request is not a valid WebSocket handshake
+// Then it will follow its script, answering pings on the way, and close the connection
+// The connection is closed as soon as the Server is closed
+func (handler *WebSocketHandler) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	handler.mutex.Lock()
+	handler.calls++
+	handler.mutex.Unlock()
+	if err := handler.Err(); err != nil {
+		handler.errorHandler.HandleError(res, req, http.StatusInternalServerError, err)
+		return
+	}
+	ws, err := acceptWebSocket(res, req, handler.subprotocol)
+	if err != nil {
+		handler.errorHandler.HandleError(res, req, http.StatusBadRequest, err)
+		return
+	}
+	defer ws.conn.Close()
+	closing := serverContext(req)
+	stop := context.AfterFunc(closing, func() {
+		ws.conn.Close()
+	})
+	defer stop()
+	err = handler.run(req, ws)
+	if err != nil && closing.Err() != nil {
+		return
+	}
+	if err != nil {
+		// The close frame is best effort, the client may be gone already
+		_ = ws.close(closeErrorCode(err, wsClosePolicyViolation), err.Error())
+		handler.errorHandler.HandleError(discardResponseWriter{}, req, http.StatusBadRequest, err)
+		return
+	}
+	if !handler.keepOpen {
+		if ws.close(wsCloseNormal, "") == nil {
+			ws.awaitClose(webSocketCloseTimeout)
+		}
+	}
+}
+
+// run follows the script, then waits for the client's close frame if the connection is kept open
+func (handler *WebSocketHandler) run(req *http.Request, ws *wsConn) error {
+	for i, step := range handler.steps {
+		var err error
+		switch {
+		case step.expect != nil:
+			err = expectMessage(ws, step)
+		case step.send != nil:
+			err = ws.writeFrame(step.send.opcode, step.send.payload)
+		case !sleep(req.Context(), step.pause):
+			err = errors.New("request cancelled")
+		}
+		if err != nil {
+			return errors.Wrapf(err, "WebSocket script step %d of %d", i+1, len(handler.steps))
+		}
+	}
+	if !handler.keepOpen {
+		return nil
+	}
+	message, err := ws.readMessage()
+	switch {
+	case err != nil:
+		return errors.Wrap(err, "WebSocket after the script")
+	case message.opcode != wsOpClose:
+		return errors.Errorf("WebSocket after the script: unexpected message %q", message.payload)
+	}
+	// Answering the client's close frame is best effort, the connection is closed anyway
+	_ = ws.writeFrame(wsOpClose, message.payload)
+	return nil
+}
+
+// expectMessage reads the next message within the step's read timeout and checks it with the step's expectation
+func expectMessage(ws *wsConn, step webSocketStep) error {
+	timeout := step.timeout
+	if timeout == 0 {
+		timeout = webSocketReadTimeout
+	}
+	if err := ws.conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return errors.Wrap(err, "Cannot set WebSocket read deadline")
+	}
+	message, err := ws.readMessage()
+	if netErr, ok := errors.Cause(err).(net.Error); ok && netErr.Timeout() {
+		return errors.Errorf("expected a message within %s", timeout)
+	}
+	if err == nil {
+		err = ws.conn.SetReadDeadline(time.Time{})
+	}
+	if err != nil {
+		return err
+	}
+	if message.opcode == wsOpClose {
+		return errors.Errorf("expected a message, the client closed the connection with %d", closeCode(message.payload))
+	}
+	return step.expect(message)
+}
+
+// discardResponseWriter is a ResponseWriter which discards everything written on it
+// It is passed to the ErrorHandler after the connection is hijacked
+type discardResponseWriter struct{}
+
+// Header returns a new empty header
+func (discardResponseWriter) Header() http.Header {
+	return make(http.Header)
+}
+
+// Write discards the data
+func (discardResponseWriter) Write(data []byte) (int, error) {
+	return len(data), nil
+}
+
+// WriteHeader discards the status
+func (discardResponseWriter) WriteHeader(int) {}
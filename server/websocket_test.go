@@ -0,0 +1,320 @@
+package server
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebSocketHandler(t *testing.T) {
+	t.Log("Testing scripted WebSocket conversations...")
+
+	srv := NewTestServer(t)
+	handler := srv.WebSocketHandler().
+		WithSubprotocol("chat").
+		WithExpectMessage("hello").
+		WithSend("welcome").
+		WithExpectMessageRegex(`^join:\w+$`).
+		WithExpectJSONSubset([]byte(`{"type":"subscribe","topic":"news"}`)).
+		WithPause(10 * time.Millisecond).
+		WithSendBinary([]byte{1, 2, 3})
+	srv.Handle("^/ws$", "GET", handler)
+	srv.Init()
+	defer srv.Close()
+
+	ws, resp := dialWebSocket(t, srv.URL+"/ws", "chat")
+	defer ws.conn.Close()
+	require.Equal(t, "chat", resp.Header.Get("Sec-WebSocket-Protocol"), "The offered subprotocol should be selected")
+
+	require.NoError(t, ws.writeFrame(wsOpText, []byte("hello")), "The message should be sent")
+	requireMessage(t, ws, wsOpText, "welcome")
+	require.NoError(t, ws.writeFrame(wsOpPing, []byte("ping")), "The ping should be sent")
+	require.NoError(t, ws.writeFrame(wsOpText, []byte("join:alice")), "The message should be sent")
+	require.NoError(t, ws.writeFrame(wsOpText, []byte(`{"topic":"news","type":"subscribe","id":1}`)),
+		"The message should be sent")
+	_, opcode, payload, err := ws.readFrame()
+	require.NoError(t, err, "The pong should be received")
+	require.Equal(t, []byte("ping"), payload, "The pong should echo the ping")
+	require.Equal(t, byte(wsOpPong), opcode, "The ping should be answered")
+	requireMessage(t, ws, wsOpBinary, "\x01\x02\x03")
+
+	message, err := ws.readMessage()
+	require.NoError(t, err, "The close frame should be received")
+	require.Equal(t, byte(wsOpClose), message.opcode, "The connection should be closed after the script")
+	require.Equal(t, uint16(wsCloseNormal), closeCode(message.payload), "The connection should be closed normally")
+	require.NoError(t, ws.close(wsCloseNormal, ""), "The close frame should be answered")
+	require.Equal(t, 1, handler.Calls(), "The connection should be counted")
+}
+
+func TestWebSocketHandler_unexpected_message(t *testing.T) {
+	t.Log("Testing unexpected WebSocket messages...")
+
+	errs := make(chan error, 1)
+	handler := NewWebSocketHandler(errorHandlerFunc(func(status int, err error) {
+		require.Equal(t, http.StatusBadRequest, status, "The status should be 400 Bad Request")
+		errs <- err
+	})).WithExpectJSON([]byte(`{"type":"subscribe"}`))
+	srv := NewServer(NewRouter(nil).WithRoute(MustNewRoute("^/ws$", nil).WithMethod("GET", handler)))
+	defer srv.Close()
+
+	ws, _ := dialWebSocket(t, srv.URL+"/ws", "")
+	defer ws.conn.Close()
+	require.NoError(t, ws.writeFrame(wsOpText, []byte(`{"type":"unsubscribe"}`)), "The message should be sent")
+	message, err := ws.readMessage()
+	require.NoError(t, err, "The close frame should be received")
+	require.Equal(t, byte(wsOpClose), message.opcode, "The connection should be closed")
+	require.Equal(t, uint16(wsClosePolicyViolation), closeCode(message.payload), "The close should report a violation")
+	require.Contains(t,
+		(<-errs).Error(),
+		`WebSocket script step 1 of 1: JSON message does not match:`+"\n"+`$.type: expected "subscribe" got "unsubscribe"`,
+		"The ErrorHandler should be called with the mismatch")
+}
+
+func TestWebSocketHandler_WithKeepOpen(t *testing.T) {
+	t.Log("Testing WebSocket connections kept open after the script...")
+
+	errs := make(chan error, 1)
+	handler := NewWebSocketHandler(errorHandlerFunc(func(status int, err error) {
+		errs <- err
+	})).WithSend("pushed").WithKeepOpen(true)
+	srv := NewServer(NewRouter(nil).WithRoute(MustNewRoute("^/ws$", nil).WithMethod("GET", handler)))
+	defer srv.Close()
+
+	ws, _ := dialWebSocket(t, srv.URL+"/ws", "")
+	requireMessage(t, ws, wsOpText, "pushed")
+	require.NoError(t, ws.close(wsCloseNormal, "bye"), "The close frame should be sent")
+	message, err := ws.readMessage()
+	require.NoError(t, err, "The close frame should be answered")
+	require.Equal(t, byte(wsOpClose), message.opcode, "The close frame should be echoed")
+	ws.conn.Close()
+
+	ws, _ = dialWebSocket(t, srv.URL+"/ws", "")
+	defer ws.conn.Close()
+	requireMessage(t, ws, wsOpText, "pushed")
+	require.NoError(t, ws.writeFrame(wsOpText, []byte("extra")), "The message should be sent")
+	require.Contains(t,
+		(<-errs).Error(),
+		`WebSocket after the script: unexpected message "extra"`,
+		"Messages after the script should be unexpected")
+}
+
+func TestWebSocketHandler_WithReadTimeout(t *testing.T) {
+	t.Log("Testing WebSocket messages arriving too late...")
+
+	errs := make(chan error, 1)
+	handler := NewWebSocketHandler(errorHandlerFunc(func(status int, err error) {
+		require.Equal(t, http.StatusBadRequest, status, "The status should be 400 Bad Request")
+		errs <- err
+	})).WithExpectMessage("hello").WithReadTimeout(20 * time.Millisecond)
+	srv := NewServer(NewRouter(nil).WithRoute(MustNewRoute("^/ws$", nil).WithMethod("GET", handler)))
+	defer srv.Close()
+
+	ws, _ := dialWebSocket(t, srv.URL+"/ws", "")
+	defer ws.conn.Close()
+	message, err := ws.readMessage()
+	require.NoError(t, err, "The close frame should be received")
+	require.Equal(t, byte(wsOpClose), message.opcode, "The connection should be closed")
+	require.Equal(t, uint16(wsClosePolicyViolation), closeCode(message.payload), "The close should report a violation")
+	require.EqualError(t,
+		<-errs,
+		"WebSocket script step 1 of 1: expected a message within 20ms",
+		"The ErrorHandler should be called with the timeout")
+
+	require.EqualError(t, NewWebSocketHandler(nil).WithSend("hi").WithReadTimeout(time.Second).Err(),
+		"Invalid WebSocketHandler options:\nRead timeout without an expected message",
+		"Only expectations should have a read timeout")
+	require.EqualError(t, NewWebSocketHandler(nil).WithExpectMessage("hi").WithReadTimeout(0).Err(),
+		"Invalid WebSocketHandler options:\nInvalid read timeout 0s", "A zero read timeout should be invalid")
+}
+
+func TestWebSocketHandler_invalid_options(t *testing.T) {
+	t.Log("Testing WebSocketHandlers with invalid options...")
+
+	errs := make(chan error, 1)
+	handler := NewWebSocketHandler(errorHandlerFunc(func(status int, err error) {
+		require.Equal(t, http.StatusInternalServerError, status, "The status should be 500 Internal Server Error")
+		errs <- err
+	})).WithExpectMessageRegex("(")
+	require.EqualError(t, handler.Err(),
+		"Invalid WebSocketHandler options:\nInvalid message regex \"(\": error parsing regexp: missing closing ): `(`",
+		"The invalid regex should be reported")
+	require.Error(t, NewRouter(nil).Handle("^/ws$", "GET", handler), "The handler should not be registered")
+
+	srv := NewServer(handler)
+	defer srv.Close()
+	resp, err := http.Get(srv.URL + "/ws")
+	require.NoError(t, err, "Test server shouldn't return any errors")
+	defer resp.Body.Close()
+	require.NotEqual(t, http.StatusSwitchingProtocols, resp.StatusCode, "An invalid handler should not upgrade")
+	require.EqualError(t, <-errs, handler.Err().Error(), "The ErrorHandler should be called with the invalid options")
+}
+
+func TestWebSocketHandler_server_close(t *testing.T) {
+	t.Log("Testing WebSocket connections closed with the server...")
+
+	errs := make(chan error, 1)
+	handler := NewWebSocketHandler(errorHandlerFunc(func(status int, err error) {
+		errs <- err
+	})).WithKeepOpen(true)
+	srv := NewServer(NewRouter(nil).WithRoute(MustNewRoute("^/ws$", nil).WithMethod("GET", handler)))
+
+	ws, _ := dialWebSocket(t, srv.URL+"/ws", "")
+	defer ws.conn.Close()
+	srv.Close()
+	require.NoError(t, ws.conn.SetReadDeadline(time.Now().Add(time.Second)), "The read deadline should be set")
+	_, err := ws.readMessage()
+	require.Error(t, err, "The connection should be closed")
+	netErr, ok := errors.Cause(err).(net.Error)
+	require.False(t, ok && netErr.Timeout(), "The connection should be closed before the read deadline")
+	select {
+	case err := <-errs:
+		require.NoError(t, err, "Closing the server should not call the ErrorHandler")
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestWebSocketHandler_protocol_errors(t *testing.T) {
+	t.Log("Testing WebSocket frames breaking the protocol...")
+
+	tests := []struct {
+		name   string
+		write  func(ws *wsConn) error
+		code   uint16
+		reason string
+	}{
+		{
+			name: "reserved bits",
+			write: func(ws *wsConn) error {
+				// Final text frame with RSV1 set, masked empty payload
+				_, err := ws.conn.Write([]byte{0xC1, 0x80, 0, 0, 0, 0})
+				return err
+			},
+			code:   wsCloseProtocolError,
+			reason: "WebSocket protocol error: reserved bits 0x40",
+		},
+		{
+			name:   "reserved data opcode",
+			write:  func(ws *wsConn) error { return ws.writeFrame(0x3, nil) },
+			code:   wsCloseProtocolError,
+			reason: "WebSocket protocol error: reserved opcode 0x3",
+		},
+		{
+			name:   "reserved control opcode",
+			write:  func(ws *wsConn) error { return ws.writeFrame(0xB, nil) },
+			code:   wsCloseProtocolError,
+			reason: "WebSocket protocol error: reserved opcode 0xb",
+		},
+		{
+			name:   "invalid UTF-8 text",
+			write:  func(ws *wsConn) error { return ws.writeFrame(wsOpText, []byte{'o', 'k', 0xFF}) },
+			code:   wsCloseInvalidPayload,
+			reason: "WebSocket text message is not valid UTF-8",
+		},
+	}
+	for _, test := range tests {
+		errs := make(chan error, 1)
+		handler := NewWebSocketHandler(errorHandlerFunc(func(status int, err error) {
+			errs <- err
+		})).WithExpectMessageRegex(".*")
+		srv := NewServer(NewRouter(nil).WithRoute(MustNewRoute("^/ws$", nil).WithMethod("GET", handler)))
+
+		ws, _ := dialWebSocket(t, srv.URL+"/ws", "")
+		require.NoError(t, test.write(ws), "The frame should be sent (%s)", test.name)
+		message, err := ws.readMessage()
+		require.NoError(t, err, "The close frame should be received (%s)", test.name)
+		require.Equal(t, byte(wsOpClose), message.opcode, "The connection should be closed (%s)", test.name)
+		require.Equal(t, test.code, closeCode(message.payload), "The close code should match (%s)", test.name)
+		require.Contains(t, (<-errs).Error(), test.reason, "The ErrorHandler should be called (%s)", test.name)
+		ws.conn.Close()
+		srv.Close()
+	}
+}
+
+func TestWsConn_close(t *testing.T) {
+	t.Log("Testing truncated WebSocket close reasons...")
+
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	server := &wsConn{
+		conn:   serverConn,
+		buffer: bufio.NewReadWriter(bufio.NewReader(serverConn), bufio.NewWriter(serverConn)),
+	}
+	client := &wsConn{
+		conn:   clientConn,
+		buffer: bufio.NewReadWriter(bufio.NewReader(clientConn), bufio.NewWriter(clientConn)),
+		client: true,
+	}
+	// The two byte é would be split by the 123rd byte
+	reason := strings.Repeat("a", 122) + "ééé"
+	errs := make(chan error, 1)
+	go func() {
+		errs <- server.close(wsCloseNormal, reason)
+		serverConn.Close()
+	}()
+
+	message, err := client.readMessage()
+	require.NoError(t, err, "The close frame should be received")
+	require.NoError(t, <-errs, "The close frame should be sent")
+	require.Equal(t, uint16(wsCloseNormal), closeCode(message.payload), "The close code should be sent")
+	require.Equal(t, strings.Repeat("a", 122), string(message.payload[2:]), "The reason should be truncated before the é")
+	require.True(t, utf8.Valid(message.payload[2:]), "The truncated reason should be valid UTF-8")
+}
+
+func TestWebSocketHandler_invalid_handshake(t *testing.T) {
+	t.Log("Testing invalid WebSocket handshakes...")
+
+	srv := NewServer(NewRouter(nil).WithRoute(MustNewRoute("^/ws$", nil).WithMethod("GET", NewWebSocketHandler(nil))))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/ws")
+	require.NoError(t, err, "Test server shouldn't return any errors")
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode, "Plain requests should be rejected")
+}
+
+// errorHandlerFunc is an ErrorHandler calling the function with the status and the error
+type errorHandlerFunc func(status int, err error)
+
+func (f errorHandlerFunc) HandleError(res http.ResponseWriter, req *http.Request, status int, err error) {
+	f(status, err)
+}
+
+// dialWebSocket opens a client WebSocket connection to the http URL, offering the subprotocol if it is not empty
+func dialWebSocket(t *testing.T, rawURL, subprotocol string) (*wsConn, *http.Response) {
+	target, err := url.Parse(rawURL)
+	require.NoError(t, err, "The URL should be valid")
+	conn, err := net.Dial("tcp", target.Host)
+	require.NoError(t, err, "The server should accept the connection")
+	req, err := http.NewRequest("GET", rawURL, nil)
+	require.NoError(t, err, "The handshake request should be created")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	if subprotocol != "" {
+		req.Header.Set("Sec-WebSocket-Protocol", subprotocol)
+	}
+	require.NoError(t, req.Write(conn), "The handshake request should be sent")
+	buffer := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+	resp, err := http.ReadResponse(buffer.Reader, req)
+	require.NoError(t, err, "The handshake response should be read")
+	require.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode, "The connection should be upgraded")
+	require.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", resp.Header.Get("Sec-WebSocket-Accept"), "The key should be accepted")
+	return &wsConn{conn: conn, buffer: buffer, client: true}, resp
+}
+
+// requireMessage reads the next message and requires it to have the opcode and payload
+func requireMessage(t *testing.T, ws *wsConn, opcode byte, payload string) {
+	message, err := ws.readMessage()
+	require.NoError(t, err, "The message should be received")
+	require.Equal(t, opcode, message.opcode, "The message type should match")
+	require.Equal(t, payload, string(message.payload), "The message should match")
+}
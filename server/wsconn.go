@@ -0,0 +1,315 @@
+package server
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// webSocketGUID is appended to the client's key to compute the accept key of the handshake (RFC 6455 1.3)
+const webSocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// maxWebSocketPayload is the largest frame payload accepted
+const maxWebSocketPayload = 16 << 20
+
+// maxCloseReason is the longest reason fitting a close frame, after its status code
+const maxCloseReason = 123
+
+// WebSocket frame opcodes
+const (
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xA
+)
+
+// WebSocket close status codes
+const (
+	wsCloseNormal          = 1000
+	wsCloseProtocolError   = 1002
+	wsCloseInvalidPayload  = 1007
+	wsClosePolicyViolation = 1008
+)
+
+// wsCloseError is an error failing the WebSocket connection with its close status code
+type wsCloseError struct {
+	code    uint16
+	message string
+}
+
+// Error returns the message of the wsCloseError
+func (err *wsCloseError) Error() string {
+	return err.message
+}
+
+// protocolError returns a wsCloseError failing the connection with 1002 (protocol error)
+func protocolError(format string, args ...interface{}) error {
+	return &wsCloseError{
+		code:    wsCloseProtocolError,
+		message: "WebSocket protocol error: " + fmt.Sprintf(format, args...),
+	}
+}
+
+// closeErrorCode returns the close status code of a wsCloseError, or the given default code for other errors
+func closeErrorCode(err error, defaultCode uint16) uint16 {
+	if closeErr, ok := errors.Cause(err).(*wsCloseError); ok {
+		return closeErr.code
+	}
+	return defaultCode
+}
+
+// wsMessage is a WebSocket message with the opcode of its first frame
+type wsMessage struct {
+	opcode  byte
+	payload []byte
+}
+
+// wsConn is a minimal RFC 6455 WebSocket connection
+// Frames written by clients are masked, the ones written by servers are not
+type wsConn struct {
+	conn       net.Conn
+	buffer     *bufio.ReadWriter
+	client     bool
+	writeMutex sync.Mutex
+}
+
+// acceptWebSocket checks the WebSocket handshake request, hijacks its connection and completes the handshake
+// The subprotocol is selected if the client offers it
+func acceptWebSocket(res http.ResponseWriter, req *http.Request, subprotocol string) (*wsConn, error) {
+	switch {
+	case req.Method != http.MethodGet:
+		return nil, errors.Errorf("WebSocket handshake: expected method GET got %s", req.Method)
+	case !headerHasToken(req.Header, "Connection", "upgrade"), !headerHasToken(req.Header, "Upgrade", "websocket"):
+		return nil, errors.New("WebSocket handshake: expected Connection: Upgrade and Upgrade: websocket headers")
+	case req.Header.Get("Sec-WebSocket-Version") != "13":
+		return nil, errors.Errorf("WebSocket handshake: expected version 13 got %q", req.Header.Get("Sec-WebSocket-Version"))
+	case req.Header.Get("Sec-WebSocket-Key") == "":
+		return nil, errors.New("WebSocket handshake: expected Sec-WebSocket-Key header")
+	}
+	hijacker, ok := res.(http.Hijacker)
+	if !ok {
+		return nil, errors.Errorf("WebSocket handshake: %T does not support hijacking", res)
+	}
+	conn, buffer, err := hijacker.Hijack()
+	if err != nil {
+		return nil, errors.Wrap(err, "WebSocket handshake: cannot hijack the connection")
+	}
+	head := "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + webSocketAccept(req.Header.Get("Sec-WebSocket-Key")) + "\r\n"
+	if subprotocol != "" && headerHasToken(req.Header, "Sec-WebSocket-Protocol", subprotocol) {
+		head += "Sec-WebSocket-Protocol: " + subprotocol + "\r\n"
+	}
+	if _, err := buffer.WriteString(head + "\r\n"); err == nil {
+		err = buffer.Flush()
+	}
+	if err != nil {
+		conn.Close()
+		return nil, errors.Wrap(err, "WebSocket handshake: cannot write the response")
+	}
+	return &wsConn{conn: conn, buffer: buffer}, nil
+}
+
+// webSocketAccept returns the Sec-WebSocket-Accept value of the given Sec-WebSocket-Key
+func webSocketAccept(key string) string {
+	hash := sha1.Sum([]byte(key + webSocketGUID))
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
+
+// headerHasToken returns true if any comma separated value of the header equals the token, case insensitively
+func headerHasToken(header http.Header, key, token string) bool {
+	for _, value := range header[http.CanonicalHeaderKey(key)] {
+		for _, field := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(field), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// writeFrame writes a single final frame with the given opcode and payload
+func (ws *wsConn) writeFrame(opcode byte, payload []byte) error {
+	ws.writeMutex.Lock()
+	defer ws.writeMutex.Unlock()
+	frame := []byte{0x80 | opcode}
+	var maskBit byte
+	if ws.client {
+		maskBit = 0x80
+	}
+	switch length := len(payload); {
+	case length < 126:
+		frame = append(frame, maskBit|byte(length))
+	case length <= 0xFFFF:
+		frame = append(frame, maskBit|126, 0, 0)
+		binary.BigEndian.PutUint16(frame[2:], uint16(length))
+	default:
+		frame = append(frame, maskBit|127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(frame[2:], uint64(length))
+	}
+	if ws.client {
+		var mask [4]byte
+		if _, err := rand.Read(mask[:]); err != nil {
+			return errors.Wrap(err, "Cannot generate WebSocket mask")
+		}
+		frame = append(frame, mask[:]...)
+		payload = maskPayload(append([]byte{}, payload...), mask)
+	}
+	frame = append(frame, payload...)
+	if _, err := ws.buffer.Write(frame); err != nil {
+		return errors.Wrap(err, "Cannot write WebSocket frame")
+	}
+	return errors.Wrap(ws.buffer.Flush(), "Cannot write WebSocket frame")
+}
+
+// readFrame reads a single frame and returns its fin bit, opcode and unmasked payload
+// Frames with reserved bits or opcodes fail the connection, as no extension is negotiated
+func (ws *wsConn) readFrame() (bool, byte, []byte, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(ws.buffer, head[:]); err != nil {
+		return false, 0, nil, errors.Wrap(err, "Cannot read WebSocket frame")
+	}
+	fin, opcode, masked := head[0]&0x80 != 0, head[0]&0x0F, head[1]&0x80 != 0
+	length, err := ws.readLength(head[1] & 0x7F)
+	switch {
+	case err != nil:
+		return false, 0, nil, err
+	case head[0]&0x70 != 0:
+		return false, 0, nil, protocolError("reserved bits 0x%x", head[0]&0x70)
+	case opcode > wsOpBinary && opcode < wsOpClose, opcode > wsOpPong:
+		return false, 0, nil, protocolError("reserved opcode 0x%x", opcode)
+	case masked == ws.client:
+		return false, 0, nil, protocolError("unexpected frame masking")
+	case length > maxWebSocketPayload, opcode >= wsOpClose && (length > 125 || !fin):
+		return false, 0, nil, protocolError("invalid frame length %d", length)
+	}
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(ws.buffer, mask[:]); err != nil {
+			return false, 0, nil, errors.Wrap(err, "Cannot read WebSocket frame")
+		}
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(ws.buffer, payload); err != nil {
+		return false, 0, nil, errors.Wrap(err, "Cannot read WebSocket frame")
+	}
+	if masked {
+		payload = maskPayload(payload, mask)
+	}
+	return fin, opcode, payload, nil
+}
+
+// readLength reads the extended payload length of a frame, if its 7 bit length requires it
+func (ws *wsConn) readLength(length byte) (uint64, error) {
+	var extended []byte
+	switch length {
+	case 126:
+		extended = make([]byte, 2)
+	case 127:
+		extended = make([]byte, 8)
+	default:
+		return uint64(length), nil
+	}
+	if _, err := io.ReadFull(ws.buffer, extended); err != nil {
+		return 0, errors.Wrap(err, "Cannot read WebSocket frame")
+	}
+	if len(extended) == 2 {
+		return uint64(binary.BigEndian.Uint16(extended)), nil
+	}
+	return binary.BigEndian.Uint64(extended), nil
+}
+
+// readMessage reads the next data or close message, assembling fragmented messages
+// Pings are answered with pongs, pongs are ignored
+// Text messages which are not valid UTF-8 fail the connection with 1007 (invalid payload)
+func (ws *wsConn) readMessage() (wsMessage, error) {
+	var message wsMessage
+	fragmented := false
+	for {
+		fin, opcode, payload, err := ws.readFrame()
+		if err != nil {
+			return message, err
+		}
+		switch {
+		case opcode == wsOpPing:
+			err = ws.writeFrame(wsOpPong, payload)
+		case opcode == wsOpPong:
+		case opcode == wsOpClose:
+			return wsMessage{opcode: opcode, payload: payload}, nil
+		case (opcode == wsOpContinuation) != fragmented:
+			err = protocolError("unexpected continuation frame")
+		default:
+			if !fragmented {
+				message.opcode = opcode
+			}
+			message.payload = append(message.payload, payload...)
+			fragmented = !fin
+			if fin && message.opcode == wsOpText && !utf8.Valid(message.payload) {
+				return message, &wsCloseError{code: wsCloseInvalidPayload, message: "WebSocket text message is not valid UTF-8"}
+			}
+			if fin {
+				return message, nil
+			}
+		}
+		if err != nil {
+			return message, err
+		}
+	}
+}
+
+// close sends a close frame with the given status code and reason,
+// which is truncated to fit the frame without splitting its last character
+func (ws *wsConn) close(code uint16, reason string) error {
+	if len(reason) > maxCloseReason {
+		end := maxCloseReason
+		for end > 0 && !utf8.RuneStart(reason[end]) {
+			end--
+		}
+		reason = reason[:end]
+	}
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, code)
+	return ws.writeFrame(wsOpClose, append(payload, reason...))
+}
+
+// awaitClose reads and drops messages until the peer's close frame arrives or the timeout expires
+func (ws *wsConn) awaitClose(timeout time.Duration) {
+	if err := ws.conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return
+	}
+	for {
+		message, err := ws.readMessage()
+		if err != nil || message.opcode == wsOpClose {
+			return
+		}
+	}
+}
+
+// closeCode returns the status code of a close frame's payload, or 1005 (no status) if it has none
+func closeCode(payload []byte) uint16 {
+	if len(payload) < 2 {
+		return 1005
+	}
+	return binary.BigEndian.Uint16(payload)
+}
+
+// maskPayload masks (or unmasks) the payload in place with the masking key and returns it
+func maskPayload(payload []byte, mask [4]byte) []byte {
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return payload
+}
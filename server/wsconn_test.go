@@ -0,0 +1,78 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWsConn_frames(t *testing.T) {
+	t.Log("Testing WebSocket frame encoding...")
+
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	defer serverConn.Close()
+	client := &wsConn{conn: clientConn, buffer: pipeBuffer(clientConn), client: true}
+	server := &wsConn{conn: serverConn, buffer: pipeBuffer(serverConn)}
+
+	for _, size := range []int{0, 125, 126, 0xFFFF, 0x10000} {
+		payload := bytes.Repeat([]byte("x"), size)
+		done := async(func() {
+			_ = client.writeFrame(wsOpBinary, payload)
+		})
+		message, err := server.readMessage()
+		<-done
+		require.NoError(t, err, "The frame of %d bytes should be read", size)
+		require.Equal(t, byte(wsOpBinary), message.opcode, "The opcode should be kept")
+		require.Equal(t, size, len(message.payload), "The payload length should be kept")
+	}
+
+	done := async(func() {
+		_, _ = client.buffer.Write(maskedFrame(wsOpText, "frag"))
+		_, _ = client.buffer.Write(maskedFrame(0x80|wsOpContinuation, "mented"))
+		_ = client.buffer.Flush()
+	})
+	message, err := server.readMessage()
+	<-done
+	require.NoError(t, err, "The fragmented message should be read")
+	require.Equal(t, "fragmented", string(message.payload), "The fragments should be assembled")
+
+	done = async(func() {
+		_ = server.writeFrame(wsOpText, []byte("unmasked"))
+	})
+	message, err = client.readMessage()
+	<-done
+	require.NoError(t, err, "The server frame should be read")
+	require.Equal(t, "unmasked", string(message.payload), "Server frames should not be masked")
+
+	done = async(func() {
+		_, _ = client.buffer.Write([]byte{0x80 | wsOpText, 1, 'x'})
+		_ = client.buffer.Flush()
+	})
+	_, err = server.readMessage()
+	<-done
+	require.EqualError(t, err, "WebSocket protocol error: unexpected frame masking", "Unmasked client frames should fail")
+}
+
+// maskedFrame returns a client frame with the given first byte and payload, masked with a zero key
+func maskedFrame(first byte, payload string) []byte {
+	return append([]byte{first, 0x80 | byte(len(payload)), 0, 0, 0, 0}, payload...)
+}
+
+// pipeBuffer returns a buffered reader and writer of the connection
+func pipeBuffer(conn net.Conn) *bufio.ReadWriter {
+	return bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+}
+
+// async runs the function in a new goroutine and returns a channel closed when it returns
+func async(f func()) chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		f()
+	}()
+	return done
+}